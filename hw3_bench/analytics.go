@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// FormatTable writes analytics as text tables
+	FormatTable = "table"
+	// FormatJSON writes analytics as json
+	FormatJSON = "json"
+
+	defaultTopN   = 10
+	otherFamily   = "Other"
+	unknownDomain = "unknown"
+)
+
+// browserRule detects browser family by token in user agent, version follows the token
+type browserRule struct {
+	family string
+	token  string
+}
+
+// order matters: Chrome user agents contain Safari, Edge ones contain Chrome and so on
+var browserRules = [...]browserRule{
+	{"Edge", "Edge/"},
+	{"Opera", "OPR/"},
+	{"Opera", "Opera/"},
+	{"MSIE", "MSIE "},
+	{"Chrome", "Chrome/"},
+	{"Firefox", "Firefox/"},
+	{"Android", "Android "},
+	{"Safari", "Version/"},
+}
+
+// AnalyticsOptions configures Analyze
+type AnalyticsOptions struct {
+	SearchOptions
+	// count of user agents in top, 0 means defaultTopN
+	TopN int
+}
+
+// Count is a number of occurrences of some value
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// VersionCount is a number of occurrences of browser family version
+type VersionCount struct {
+	Family  string `json:"family"`
+	Version string `json:"version"`
+	Count   int    `json:"count"`
+}
+
+// Analytics is a report about browsers and emails of users
+type Analytics struct {
+	Users        int                       `json:"users"`
+	Families     []Count                   `json:"families"`
+	Versions     []VersionCount            `json:"versions"`
+	TopAgents    []Count                   `json:"top_agents"`
+	CoOccurrence map[string]map[string]int `json:"co_occurrence"`
+	EmailDomains []Count                   `json:"email_domains"`
+}
+
+type versionKey struct {
+	family  string
+	version string
+}
+
+type analyzer struct {
+	users        int
+	families     map[string]int
+	versions     map[versionKey]int
+	agents       map[string]int
+	coOccurrence map[string]map[string]int
+	domains      map[string]int
+	// families of current user
+	userFamilies map[string]bool
+}
+
+// parseBrowser returns browser family and its major version
+func parseBrowser(agent string) (string, string) {
+	for _, rule := range browserRules {
+		idx := strings.Index(agent, rule.token)
+		if idx < 0 {
+			continue
+		}
+		version := agent[idx+len(rule.token):]
+		if end := strings.IndexAny(version, " ;)"); end >= 0 {
+			version = version[:end]
+		}
+		if end := strings.IndexByte(version, '.'); end >= 0 {
+			version = version[:end]
+		}
+		return rule.family, version
+	}
+	return otherFamily, ""
+}
+
+func emailDomain(email string) string {
+	idx := strings.LastIndexByte(email, '@')
+	if idx < 0 || idx == len(email)-1 {
+		return unknownDomain
+	}
+	return strings.ToLower(email[idx+1:])
+}
+
+func (a *analyzer) add(user *User) {
+	a.users++
+	a.domains[emailDomain(user.Email)]++
+
+	for k := range a.userFamilies {
+		delete(a.userFamilies, k)
+	}
+	for _, agent := range user.Browsers {
+		family, version := parseBrowser(agent)
+		a.families[family]++
+		a.versions[versionKey{family, version}]++
+		a.agents[agent]++
+		a.userFamilies[family] = true
+	}
+
+	for f1 := range a.userFamilies {
+		row, ok := a.coOccurrence[f1]
+		if !ok {
+			row = make(map[string]int)
+			a.coOccurrence[f1] = row
+		}
+		for f2 := range a.userFamilies {
+			row[f2]++
+		}
+	}
+}
+
+// sortCounts converts map to slice sorted by count desc and name asc
+func sortCounts(m map[string]int) []Count {
+	res := make([]Count, 0, len(m))
+	for name, count := range m {
+		res = append(res, Count{name, count})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+func (a *analyzer) result(topN int) *Analytics {
+	res := &Analytics{
+		Users:        a.users,
+		Families:     sortCounts(a.families),
+		TopAgents:    sortCounts(a.agents),
+		CoOccurrence: a.coOccurrence,
+		EmailDomains: sortCounts(a.domains),
+	}
+	if len(res.TopAgents) > topN {
+		res.TopAgents = res.TopAgents[:topN]
+	}
+
+	res.Versions = make([]VersionCount, 0, len(a.versions))
+	for k, count := range a.versions {
+		res.Versions = append(res.Versions, VersionCount{k.family, k.version, count})
+	}
+	sort.Slice(res.Versions, func(i, j int) bool {
+		vi, vj := res.Versions[i], res.Versions[j]
+		if vi.Family != vj.Family {
+			return vi.Family < vj.Family
+		}
+		if vi.Count != vj.Count {
+			return vi.Count > vj.Count
+		}
+		return vi.Version < vj.Version
+	})
+	return res
+}
+
+// Analyze collects browsers and emails statistics of users from in
+func Analyze(in io.Reader, opts AnalyticsOptions) (*Analytics, *Report, error) {
+	a := &analyzer{
+		families:     make(map[string]int),
+		versions:     make(map[versionKey]int),
+		agents:       make(map[string]int),
+		coOccurrence: make(map[string]map[string]int),
+		domains:      make(map[string]int),
+		userFamilies: make(map[string]bool),
+	}
+
+	report, err := scanUsers(in, opts.SearchOptions, func(i int, user *User) {
+		a.add(user)
+	})
+	if err != nil {
+		return nil, report, err
+	}
+
+	topN := opts.TopN
+	if topN <= 0 {
+		topN = defaultTopN
+	}
+	return a.result(topN), report, nil
+}
+
+// AnalyticsSearch analyzes users file from opts.Path and writes report in format
+func AnalyticsSearch(out io.Writer, format string, opts AnalyticsOptions) (*Report, error) {
+	file, err := openFile(opts.path())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	res, report, err := Analyze(file, opts)
+	if err != nil {
+		return report, err
+	}
+	return report, res.Write(out, format)
+}
+
+// Write writes analytics in format
+func (a *Analytics) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		return a.WriteJSON(w)
+	case FormatTable, "":
+		return a.WriteTable(w)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// WriteJSON writes analytics as json
+func (a *Analytics) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+// WriteTable writes analytics as text tables
+func (a *Analytics) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	writeCounts := func(title string, counts []Count) {
+		fmt.Fprintf(tw, "%s\tcount\n", title)
+		for _, c := range counts {
+			fmt.Fprintf(tw, "%s\t%d\n", c.Name, c.Count)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintf(tw, "users\t%d\n\n", a.Users)
+	writeCounts("family", a.Families)
+
+	fmt.Fprintln(tw, "family\tversion\tcount")
+	for _, v := range a.Versions {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", v.Family, v.Version, v.Count)
+	}
+	fmt.Fprintln(tw)
+
+	writeCounts("user agent", a.TopAgents)
+
+	families := make([]string, 0, len(a.CoOccurrence))
+	for f := range a.CoOccurrence {
+		families = append(families, f)
+	}
+	sort.Strings(families)
+	fmt.Fprintf(tw, "co-occurrence\t%s\n", strings.Join(families, "\t"))
+	for _, f1 := range families {
+		fmt.Fprint(tw, f1)
+		for _, f2 := range families {
+			fmt.Fprintf(tw, "\t%d", a.CoOccurrence[f1][f2])
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintln(tw)
+
+	writeCounts("email domain", a.EmailDomains)
+	return tw.Flush()
+}
@@ -5,23 +5,42 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"regexp"
 	"strings"
 	// "log"
 )
 
-const filePath string = "./data/users.txt"
+// path to users file, it is variable to be replaced in tests
+var filePath = "./data/users.txt"
 
-func SlowSearch(out io.Writer) {
-	file, err := os.Open(filePath)
+// SlowSearch searches users with Android and MSIE browsers, it stops on first bad record
+func SlowSearch(out io.Writer) error {
+	_, err := SlowSearchWithOptions(out, SearchOptions{})
+	return err
+}
+
+// SlowSearchWithOptions is SlowSearch with configurable handling of bad records
+func SlowSearchWithOptions(out io.Writer, opts SearchOptions) (*Report, error) {
+	file, err := openFile(opts.path())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return SlowSearchReader(out, file, opts)
+}
+
+// SlowSearchReader is SlowSearch over users from in, compressed input is detected automatically
+func SlowSearchReader(out io.Writer, in io.Reader, opts SearchOptions) (*Report, error) {
+	src, err := NewSource(in)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer src.Close()
 
-	fileContents, err := ioutil.ReadAll(file)
+	fileContents, err := ioutil.ReadAll(src)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	r := regexp.MustCompile("@")
@@ -29,20 +48,28 @@ func SlowSearch(out io.Writer) {
 	uniqueBrowsers := 0
 	foundUsers := ""
 
-	lines := strings.Split(string(fileContents), "\n")
+	lines := strings.Split(strings.TrimSuffix(string(fileContents), "\n"), "\n")
 
+	report := &Report{}
+	// bad records are kept as nil to save numbering of users
 	users := make([]map[string]interface{}, 0)
-	for _, line := range lines {
+	for i, line := range lines {
 		user := make(map[string]interface{})
 		// fmt.Printf("%v %v\n", err, line)
 		err := json.Unmarshal([]byte(line), &user)
 		if err != nil {
-			panic(err)
+			if err := report.add(opts.Policy, i+1, err); err != nil {
+				return report, err
+			}
+			user = nil
 		}
 		users = append(users, user)
 	}
 
 	for i, user := range users {
+		if user == nil {
+			continue
+		}
 
 		isAndroid := false
 		isMSIE := false
@@ -102,10 +129,12 @@ func SlowSearch(out io.Writer) {
 		}
 
 		// log.Println("Android and MSIE user:", user["name"], user["email"])
-		email := r.ReplaceAllString(user["email"].(string), " [at] ")
+		email, _ := user["email"].(string)
+		email = r.ReplaceAllString(email, " [at] ")
 		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, user["name"], email)
 	}
 
 	fmt.Fprintln(out, "found users:\n"+foundUsers)
 	fmt.Fprintln(out, "Total unique browsers", len(seenBrowsers))
+	return report, nil
 }
@@ -4,30 +4,35 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 
 	"github.com/mailru/easyjson"
 )
 
 // вам надо написать более быструю оптимальную этой функции
-func FastSearch(out io.Writer) {
-	file, err := os.Open(filePath)
+func FastSearch(out io.Writer) error {
+	_, err := FastSearchWithOptions(out, SearchOptions{})
+	return err
+}
+
+// FastSearchWithOptions is FastSearch with configurable handling of bad records
+func FastSearchWithOptions(out io.Writer, opts SearchOptions) (*Report, error) {
+	file, err := openFile(opts.path())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer file.Close()
 
+	return FastSearchReader(out, file, opts)
+}
+
+// FastSearchReader is FastSearch over users from in, compressed input is detected automatically
+func FastSearchReader(out io.Writer, in io.Reader, opts SearchOptions) (*Report, error) {
 	seenBrowsers := make(map[string]bool)
 	uniqueBrowsers := 0
-	i := 0
-	user := &User{}
 
 	fmt.Fprintln(out, "found users:")
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		readUser(scanner.Bytes(), user)
-
+	report, err := scanUsers(in, opts, func(i int, user *User) {
 		browsers := user.Browsers
 
 		findAndroid, uniqueAndroid := readBrowser(browsers, "Android", seenBrowsers)
@@ -36,26 +41,55 @@ func FastSearch(out io.Writer) {
 		uniqueBrowsers += uniqueMSIE
 
 		if !(findAndroid && findMSIE) {
-			i++
-			continue
+			return
 		}
 
 		email := strings.Replace(user.Email, "@", " [at] ", -1)
 		fmt.Fprintf(out, "[%d] %s <%s>\n", i, user.Name, email)
-		i++
-	}
-	if err := scanner.Err(); err != nil {
-		panic(err)
+	})
+	if err != nil {
+		return report, err
 	}
 
 	fmt.Fprintln(out, "\nTotal unique browsers", len(seenBrowsers))
+	return report, nil
 }
 
-func readUser(b []byte, user *User) {
-	err := easyjson.Unmarshal(b, user)
+// scanUsers reads users line by line and calls fn for each valid one with its index.
+// user is reused between calls, so fn must not keep it.
+func scanUsers(in io.Reader, opts SearchOptions, fn func(i int, user *User)) (*Report, error) {
+	src, err := NewSource(in)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer src.Close()
+
+	i := 0
+	user := &User{}
+	report := &Report{}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, initialLineSize), opts.maxLineSize())
+	for ; scanner.Scan(); i++ {
+		if err := readUser(scanner.Bytes(), user); err != nil {
+			if err := report.add(opts.Policy, i+1, err); err != nil {
+				return report, err
+			}
+			continue
+		}
+		fn(i, user)
+	}
+	if err := scanner.Err(); err != nil {
+		// line which caused error is the next one after last scanned
+		return report, &RecordError{Line: i + 1, Err: err}
+	}
+	return report, nil
+}
+
+func readUser(b []byte, user *User) error {
+	// user is reused between lines, so fields absent in current line must not keep old values
+	user.Name, user.Email, user.Browsers = "", "", user.Browsers[:0]
+	return easyjson.Unmarshal(b, user)
 }
 
 func readBrowser(browsers []string, substr string, seen map[string]bool) (bool, int) {
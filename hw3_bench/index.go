@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// indexSuffix is an extension of index files
+const indexSuffix = ".idx"
+
+// indexDir is a directory of indexes in user cache dir
+const indexDir = "hw3_bench"
+
+// indexVersion is a version of index format, indexes of other versions are stale
+const indexVersion = 1
+
+var (
+	errIndexStale      = errors.New("index is stale")
+	errIndexCompressed = errors.New("index can't be built for compressed file")
+	errLineOutOfRange  = errors.New("line out of range")
+	errIndexStdin      = errors.New("index can't be built for stdin, users file path is required")
+)
+
+// Index is an inverted index of users file. Lines are numbered from 0 like users in search output.
+type Index struct {
+	Version int
+	// source file and its state at the moment of building, Hash is SHA-1 of its content
+	Source  string
+	Size    int64
+	ModTime int64
+	Hash    []byte
+	// Offsets[i] is the start of line i, the last element is the size of file
+	Offsets []int64
+	// browser token -> lines of users having browser with this token
+	Browsers map[string][]int
+	// browser token -> distinct browsers with this token
+	Agents map[string][]string
+	// lower case name -> lines
+	Names map[string][]int
+	// lower case email -> lines
+	Emails map[string][]int
+}
+
+// IndexPath returns default path of index for users file: file in user cache dir,
+// temp dir is used if there is no cache dir. Name of index depends on absolute path of users file.
+func IndexPath(path string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha1.Sum([]byte(path))
+	name := fmt.Sprintf("%s-%x%s", filepath.Base(path), sum[:8], indexSuffix)
+	return filepath.Join(dir, indexDir, name)
+}
+
+// browserTokens splits browser to words of letters and digits
+func browserTokens(browser string) []string {
+	return strings.FieldsFunc(browser, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// addLine adds line to the end of list if it is not there yet, lines are added in ascending order
+func addLine(m map[string][]int, key string, line int) {
+	lines := m[key]
+	if len(lines) > 0 && lines[len(lines)-1] == line {
+		return
+	}
+	m[key] = append(lines, line)
+}
+
+// BuildIndex scans uncompressed users file and builds its index
+func BuildIndex(path string, opts SearchOptions) (*Index, *Report, error) {
+	if path == stdinPath {
+		return nil, nil, errIndexStdin
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idx := &Index{
+		Version:  indexVersion,
+		Source:   path,
+		Size:     stat.Size(),
+		ModTime:  stat.ModTime().UnixNano(),
+		Browsers: make(map[string][]int),
+		Agents:   make(map[string][]string),
+		Names:    make(map[string][]int),
+		Emails:   make(map[string][]int),
+	}
+	report := &Report{}
+	user := &User{}
+	seen := make(map[string]bool)
+
+	hash := sha1.New()
+	reader := bufio.NewReaderSize(io.TeeReader(file, hash), initialLineSize)
+	if head, _ := reader.Peek(len(zstdMagic)); isCompressed(head) {
+		return nil, nil, errIndexCompressed
+	}
+
+	var offset int64
+	for i := 0; ; i++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, report, err
+		}
+		if len(line) > opts.maxLineSize() {
+			return nil, report, &RecordError{Line: i + 1, Err: bufio.ErrTooLong}
+		}
+		idx.Offsets = append(idx.Offsets, offset)
+		offset += int64(len(line))
+
+		if err := readUser(bytes.TrimRight(line, "\r\n"), user); err != nil {
+			if err := report.add(opts.Policy, i+1, err); err != nil {
+				return nil, report, err
+			}
+			continue
+		}
+
+		addLine(idx.Names, strings.ToLower(user.Name), i)
+		addLine(idx.Emails, strings.ToLower(user.Email), i)
+		for _, browser := range user.Browsers {
+			for _, token := range browserTokens(browser) {
+				addLine(idx.Browsers, token, i)
+				if key := token + "\x00" + browser; !seen[key] {
+					seen[key] = true
+					idx.Agents[token] = append(idx.Agents[token], browser)
+				}
+			}
+		}
+	}
+	idx.Offsets = append(idx.Offsets, offset)
+	idx.Hash = hash.Sum(nil)
+
+	return idx, report, nil
+}
+
+// Save writes index to path atomically, directory of path is created if it is absent
+func (idx *Index) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Stale checks if index has other format or source file has been changed after index was built.
+// Content is compared too because file can be changed without change of size and modification time.
+func (idx *Index) Stale() bool {
+	if idx.Version != indexVersion {
+		return true
+	}
+	file, err := os.Open(idx.Source)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil || stat.Size() != idx.Size || stat.ModTime().UnixNano() != idx.ModTime {
+		return true
+	}
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return true
+	}
+	return !bytes.Equal(hash.Sum(nil), idx.Hash)
+}
+
+// LoadIndex reads index from path. It returns errIndexStale if source file has been changed.
+func LoadIndex(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	idx := &Index{}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(idx); err != nil {
+		return nil, fmt.Errorf("cant read index %s: %s", path, err)
+	}
+	if idx.Stale() {
+		return idx, errIndexStale
+	}
+	return idx, nil
+}
+
+// OpenIndex loads index of users file from opts.IndexPath or builds and saves it if index is absent or stale
+func OpenIndex(path string, opts SearchOptions) (*Index, error) {
+	if path == stdinPath {
+		return nil, errIndexStdin
+	}
+	idx, err := LoadIndex(opts.indexPath())
+	// index of other file can be saved by opts.IndexPath
+	if err == nil && idx.Source == path {
+		return idx, nil
+	}
+
+	idx, _, err = BuildIndex(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.Save(opts.indexPath()); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// intersect returns lines existing in both sorted lists
+func intersect(a, b []int) []int {
+	res := make([]int, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+// Query returns sorted lines of users having browsers with all tokens
+func (idx *Index) Query(tokens ...string) []int {
+	if len(tokens) == 0 {
+		return nil
+	}
+	res := idx.Browsers[tokens[0]]
+	for _, token := range tokens[1:] {
+		res = intersect(res, idx.Browsers[token])
+	}
+	return res
+}
+
+// LookupName returns lines of users with name, case insensitive
+func (idx *Index) LookupName(name string) []int {
+	return idx.Names[strings.ToLower(name)]
+}
+
+// LookupEmail returns lines of users with email, case insensitive
+func (idx *Index) LookupEmail(email string) []int {
+	return idx.Emails[strings.ToLower(email)]
+}
+
+// UniqueBrowsers returns count of distinct browsers having any of tokens
+func (idx *Index) UniqueBrowsers(tokens ...string) int {
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		for _, browser := range idx.Agents[token] {
+			seen[browser] = true
+		}
+	}
+	return len(seen)
+}
+
+// ReadUser reads user on line from source file
+func (idx *Index) ReadUser(r io.ReaderAt, line int, user *User) error {
+	if line < 0 || line >= len(idx.Offsets)-1 {
+		return errLineOutOfRange
+	}
+	start, end := idx.Offsets[line], idx.Offsets[line+1]
+	buf := make([]byte, end-start)
+	if _, err := r.ReadAt(buf, start); err != nil && err != io.EOF {
+		return err
+	}
+	return readUser(bytes.TrimRight(buf, "\r\n"), user)
+}
+
+// IndexedSearch finds users having browsers with all tokens using index of opts.Path.
+// Output is the same as FastSearch when tokens are Android and MSIE.
+func IndexedSearch(out io.Writer, opts SearchOptions, tokens ...string) error {
+	path := opts.path()
+	idx, err := OpenIndex(path, opts)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lines := idx.Query(tokens...)
+	user := &User{}
+	fmt.Fprintln(out, "found users:")
+	for _, line := range lines {
+		if err := idx.ReadUser(file, line, user); err != nil {
+			return &RecordError{Line: line + 1, Err: err}
+		}
+		email := strings.Replace(user.Email, "@", " [at] ", -1)
+		fmt.Fprintf(out, "[%d] %s <%s>\n", line, user.Name, email)
+	}
+	fmt.Fprintln(out, "\nTotal unique browsers", idx.UniqueBrowsers(tokens...))
+	return nil
+}
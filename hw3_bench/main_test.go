@@ -1,9 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 // запускаем перед основными функциями по разу чтобы файл остался в памяти в файловом кеше
@@ -30,6 +41,359 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+const badUsers = `{"name":"Ann","email":"ann@mail.ru","browsers":["Android 4.0","MSIE 8.0"]}
+{"name":"Bob",
+{"name":"Joe","email":"joe@mail.ru","browsers":["MSIE 9.0","Android 5.0"]}
+not a json
+`
+
+func withUsersFile(t *testing.T, content string) {
+	f, err := ioutil.TempFile("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(content)
+	f.Close()
+
+	old := filePath
+	filePath = f.Name()
+	t.Cleanup(func() {
+		filePath = old
+		os.Remove(f.Name())
+	})
+}
+
+type searchFunc func(io.Writer, SearchOptions) (*Report, error)
+
+func TestSearchBadRecords(t *testing.T) {
+	withUsersFile(t, badUsers)
+
+	for name, search := range map[string]searchFunc{
+		"slow": SlowSearchWithOptions,
+		"fast": FastSearchWithOptions,
+	} {
+		t.Run(name+" abort", func(t *testing.T) {
+			_, err := search(ioutil.Discard, SearchOptions{})
+			recErr := &RecordError{}
+			if !errors.As(err, &recErr) || recErr.Line != 2 {
+				t.Errorf("expected error on line 2, got %v", err)
+			}
+		})
+		t.Run(name+" skip", func(t *testing.T) {
+			out := new(bytes.Buffer)
+			report, err := search(out, SearchOptions{Policy: PolicySkip})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Skipped != 2 || len(report.Records) != 0 {
+				t.Errorf("unexpected report %+v", report)
+			}
+			if !strings.Contains(out.String(), "[2] Joe <joe [at] mail.ru>") {
+				t.Errorf("unexpected result:\n%s", out)
+			}
+		})
+		t.Run(name+" collect", func(t *testing.T) {
+			report, err := search(ioutil.Discard, SearchOptions{Policy: PolicyCollect})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := []int{}
+			for _, r := range report.Records {
+				lines = append(lines, r.Line)
+			}
+			if report.Skipped != 2 || !reflect.DeepEqual(lines, []int{2, 4}) {
+				t.Errorf("unexpected report %+v", report)
+			}
+		})
+	}
+}
+
+func TestSearchNoFile(t *testing.T) {
+	withUsersFile(t, "")
+	filePath = filePath + ".missing"
+
+	if err := SlowSearch(ioutil.Discard); !os.IsNotExist(err) {
+		t.Errorf("slow: expected not exist error, got %v", err)
+	}
+	if err := FastSearch(ioutil.Discard); !os.IsNotExist(err) {
+		t.Errorf("fast: expected not exist error, got %v", err)
+	}
+}
+
+func TestFastSearchLongLine(t *testing.T) {
+	long := `{"name":"Ann","email":"ann@mail.ru","browsers":["Android","MSIE ` + strings.Repeat("x", 100*1024) + `"]}`
+	withUsersFile(t, long)
+
+	out := new(bytes.Buffer)
+	if err := FastSearch(out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[0] Ann") {
+		t.Errorf("unexpected result:\n%s", out)
+	}
+
+	_, err := FastSearchWithOptions(ioutil.Discard, SearchOptions{MaxLineSize: 1024})
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("expected too long error, got %v", err)
+	}
+}
+
+func compressFile(t *testing.T, name string, compress func(io.Writer) io.WriteCloser) string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	w := compress(f)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestSearchCompressed(t *testing.T) {
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+
+	paths := map[string]string{
+		"gzip": compressFile(t, "users.gz", func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		}),
+		"zstd": compressFile(t, "users.zst", func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		}),
+	}
+
+	for name, path := range paths {
+		for searchName, search := range map[string]searchFunc{
+			"slow": SlowSearchWithOptions,
+			"fast": FastSearchWithOptions,
+		} {
+			t.Run(name+" "+searchName, func(t *testing.T) {
+				out := new(bytes.Buffer)
+				if _, err := search(out, SearchOptions{Path: path}); err != nil {
+					t.Fatal(err)
+				}
+				if out.String() != expected.String() {
+					t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out, expected)
+				}
+			})
+		}
+	}
+}
+
+// standard library has only reader of bzip2, so fixture keeps the first lines of users file
+const (
+	bzip2Fixture      = "./testdata/users.txt.bz2"
+	bzip2FixtureLines = 20
+)
+
+func TestSearchBzip2(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfterN(data, []byte("\n"), bzip2FixtureLines+1)
+	expected := new(bytes.Buffer)
+	if _, err := FastSearchReader(expected, bytes.NewReader(bytes.Join(lines[:bzip2FixtureLines], nil)), SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, search := range map[string]searchFunc{
+		"slow": SlowSearchWithOptions,
+		"fast": FastSearchWithOptions,
+	} {
+		out := new(bytes.Buffer)
+		if _, err := search(out, SearchOptions{Path: bzip2Fixture}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, out, expected)
+		}
+	}
+}
+
+func TestSearchReader(t *testing.T) {
+	out := new(bytes.Buffer)
+	if _, err := FastSearchReader(out, strings.NewReader(badUsers), SearchOptions{Policy: PolicySkip}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "found users:\n[0] Ann <ann [at] mail.ru>\n[2] Joe <joe [at] mail.ru>\n\nTotal unique browsers 4\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out, expected)
+	}
+}
+
+func TestParseBrowser(t *testing.T) {
+	for agent, expected := range map[string][2]string{
+		"Mozilla/4.0 (compatible; MSIE 7.0; Windows Phone OS 7.0; Trident/3.1; IEMobile/7.0)":                                              {"MSIE", "7"},
+		"Mozilla/5.0 (Windows NT 6.1; Win64; x64; rv:35.0) Gecko/20100101 Firefox/35.0":                                                    {"Firefox", "35"},
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML like Gecko) Chrome/36.0.1985.125 Safari/537.36":                         {"Chrome", "36"},
+		"Mozilla/5.0 (Linux; U; Android 3.0.1; en-us; GT-P7100 Build/HRI83) AppleWebkit/534.13 (KHTML, like Gecko) Version/4.0 Safari/534": {"Android", "3"},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_7_5) AppleWebKit/536.26.17 (KHTML like Gecko) Version/6.0.2 Safari/536.26.17":           {"Safari", "6"},
+		"Jigsaw/2.2.5 W3C_CSS_Validator_JFouffa/2.0":                                                                                       {"Other", ""},
+	} {
+		family, version := parseBrowser(agent)
+		if family != expected[0] || version != expected[1] {
+			t.Errorf("%s: expected %v, got %s %s", agent, expected, family, version)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	res, report, err := Analyze(strings.NewReader(badUsers), AnalyticsOptions{
+		SearchOptions: SearchOptions{Policy: PolicySkip},
+		TopN:          1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 2 || res.Users != 2 {
+		t.Errorf("unexpected users %d, skipped %d", res.Users, report.Skipped)
+	}
+	if !reflect.DeepEqual(res.Families, []Count{{"Android", 2}, {"MSIE", 2}}) {
+		t.Errorf("unexpected families %+v", res.Families)
+	}
+	if len(res.TopAgents) != 1 || len(res.Versions) != 4 {
+		t.Errorf("unexpected agents %+v and versions %+v", res.TopAgents, res.Versions)
+	}
+	if res.CoOccurrence["Android"]["MSIE"] != 2 || res.CoOccurrence["MSIE"]["MSIE"] != 2 {
+		t.Errorf("unexpected co-occurrence %+v", res.CoOccurrence)
+	}
+	if !reflect.DeepEqual(res.EmailDomains, []Count{{"mail.ru", 2}}) {
+		t.Errorf("unexpected domains %+v", res.EmailDomains)
+	}
+}
+
+func TestAnalyticsSearch(t *testing.T) {
+	out := new(bytes.Buffer)
+	if _, err := AnalyticsSearch(out, FormatJSON, AnalyticsOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	res := &Analytics{}
+	if err := json.Unmarshal(out.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Users != 1000 || len(res.TopAgents) != defaultTopN {
+		t.Errorf("unexpected users %d, top agents %d", res.Users, len(res.TopAgents))
+	}
+
+	out.Reset()
+	if _, err := AnalyticsSearch(out, FormatTable, AnalyticsOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "users  1000") {
+		t.Errorf("unexpected table:\n%s", out)
+	}
+
+	if _, err := AnalyticsSearch(ioutil.Discard, "xml", AnalyticsOptions{}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestIndexedSearch(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.txt")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(dir, "index", "users.idx")
+	opts := SearchOptions{Path: path, IndexPath: indexPath}
+
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+
+	out := new(bytes.Buffer)
+	if err := IndexedSearch(out, opts, "Android", "MSIE"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out, expected)
+	}
+
+	idx, err := LoadIndex(indexPath)
+	if err != nil {
+		t.Fatalf("index is not saved: %s", err)
+	}
+	lines := idx.LookupEmail("eum_rerum_explicabo@TOPICZOOM.info")
+	user := &User{}
+	f, _ := os.Open(path)
+	defer f.Close()
+	if len(lines) != 1 || idx.ReadUser(f, lines[0], user) != nil || user.Name != "Susan Ellis" {
+		t.Errorf("unexpected lookup result %v, %+v", lines, user)
+	}
+
+	// changed source invalidates index
+	data = append([]byte(`{"name":"Ann","email":"ann@mail.ru","browsers":["Android 4.0","MSIE 8.0"]}`+"\n"), data...)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(indexPath); err != errIndexStale {
+		t.Errorf("expected stale index, got %v", err)
+	}
+	out.Reset()
+	if err := IndexedSearch(out, opts, "Android", "MSIE"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "found users:\n[0] Ann <ann [at] mail.ru>\n") {
+		t.Errorf("index is not rebuilt:\n%s", out)
+	}
+
+	// change of content without change of size and modification time is found by hash
+	stat, _ := os.Stat(path)
+	copy(data, `{"name":"Bob"`)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, stat.ModTime(), stat.ModTime())
+	if _, err := LoadIndex(indexPath); err != errIndexStale {
+		t.Errorf("expected stale index after change of content, got %v", err)
+	}
+
+	// index of other format is stale
+	idx, _, err = BuildIndex(path, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Version = indexVersion + 1
+	if err := idx.Save(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(indexPath); err != errIndexStale {
+		t.Errorf("expected stale index of other version, got %v", err)
+	}
+
+	// default index is kept in cache dir, not next to users file
+	if cache, err := os.UserCacheDir(); err == nil && filepath.Dir(IndexPath(path)) != filepath.Join(cache, indexDir) {
+		t.Errorf("expected default index in %s, got %s", filepath.Join(cache, indexDir), IndexPath(path))
+	}
+
+	if err := IndexedSearch(out, SearchOptions{Path: stdinPath}, "Android"); err != errIndexStdin {
+		t.Errorf("expected stdin error, got %v", err)
+	}
+}
+
+func TestBuildIndexCompressed(t *testing.T) {
+	path := compressFile(t, "users.gz", func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+	if _, _, err := BuildIndex(path, SearchOptions{}); err != errIndexCompressed {
+		t.Errorf("expected compressed error, got %v", err)
+	}
+}
+
 // -----
 // go test -bench . -benchmem
 
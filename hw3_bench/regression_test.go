@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// -----
+// go test -run TestPerfRegression -perf.check
+// go test -run TestPerfRegression -perf.update - write new baseline
+
+var (
+	perfCheck     = flag.Bool("perf.check", false, "compare benchmarks of FastSearch with baseline")
+	perfUpdate    = flag.Bool("perf.update", false, "write benchmarks results to baseline file")
+	perfBaseline  = flag.String("perf.baseline", "testdata/perf_baseline.json", "path to baseline file")
+	perfUsers     = flag.Int("perf.users", 1000, "count of users in synthetic dataset")
+	perfSeed      = flag.Int64("perf.seed", 1, "seed of synthetic dataset")
+	perfMaxNs     = flag.Float64("perf.ns", 1.5, "max ratio of ns/op to baseline")
+	perfMaxBytes  = flag.Float64("perf.bytes", 1.1, "max ratio of B/op to baseline")
+	perfMaxAllocs = flag.Float64("perf.allocs", 1.1, "max ratio of allocs/op to baseline")
+)
+
+type benchResult struct {
+	NsPerOp     int64 `json:"ns_per_op"`
+	BytesPerOp  int64 `json:"bytes_per_op"`
+	AllocsPerOp int64 `json:"allocs_per_op"`
+}
+
+type perfBaselineFile struct {
+	GOOS   string                 `json:"goos"`
+	GOARCH string                 `json:"goarch"`
+	Users  int                    `json:"users"`
+	Seed   int64                  `json:"seed"`
+	Bench  map[string]benchResult `json:"bench"`
+}
+
+func runBench(search searchFunc, path string) benchResult {
+	res := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			search(ioutil.Discard, SearchOptions{Path: path})
+		}
+	})
+	return benchResult{res.NsPerOp(), res.AllocedBytesPerOp(), res.AllocsPerOp()}
+}
+
+func checkRatio(t *testing.T, name string, got, baseline int64, max float64) {
+	if baseline == 0 {
+		return
+	}
+	ratio := float64(got) / float64(baseline)
+	if ratio > max {
+		t.Errorf("%s regression: %d vs baseline %d (x%.2f, max x%.2f)", name, got, baseline, ratio, max)
+	} else {
+		t.Logf("%s: %d vs baseline %d (x%.2f)", name, got, baseline, ratio)
+	}
+}
+
+func TestPerfRegression(t *testing.T) {
+	if !*perfCheck && !*perfUpdate {
+		t.Skip("use -perf.check to compare with baseline or -perf.update to write it")
+	}
+
+	baseline := perfBaselineFile{}
+	if !*perfUpdate {
+		data, err := ioutil.ReadFile(*perfBaseline)
+		if err != nil {
+			t.Fatalf("cant read baseline, run with -perf.update: %s", err)
+		}
+		if err := json.Unmarshal(data, &baseline); err != nil {
+			t.Fatal(err)
+		}
+		// absolute numbers of other platform can't be compared
+		if baseline.GOOS != runtime.GOOS || baseline.GOARCH != runtime.GOARCH {
+			t.Skipf("baseline was recorded on %s/%s, current platform is %s/%s, run with -perf.update to record baseline of this platform",
+				baseline.GOOS, baseline.GOARCH, runtime.GOOS, runtime.GOARCH)
+		}
+		if baseline.Users != *perfUsers || baseline.Seed != *perfSeed {
+			t.Fatalf("baseline was recorded for %d users with seed %d, run with the same -perf.users and -perf.seed",
+				baseline.Users, baseline.Seed)
+		}
+	}
+
+	f, err := ioutil.TempFile("", "synthetic_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := generateUsers(f, *perfUsers, *perfSeed); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	current := perfBaselineFile{
+		GOOS:   runtime.GOOS,
+		GOARCH: runtime.GOARCH,
+		Users:  *perfUsers,
+		Seed:   *perfSeed,
+		Bench: map[string]benchResult{
+			"SlowSearch": runBench(SlowSearchWithOptions, f.Name()),
+			"FastSearch": runBench(FastSearchWithOptions, f.Name()),
+		},
+	}
+
+	if *perfUpdate {
+		data, _ := json.MarshalIndent(current, "", "  ")
+		os.MkdirAll(filepath.Dir(*perfBaseline), 0755)
+		if err := ioutil.WriteFile(*perfBaseline, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("baseline written to %s: %+v", *perfBaseline, current.Bench)
+		return
+	}
+
+	got, base := current.Bench["FastSearch"], baseline.Bench["FastSearch"]
+	checkRatio(t, "ns/op", got.NsPerOp, base.NsPerOp, *perfMaxNs)
+	checkRatio(t, "B/op", got.BytesPerOp, base.BytesPerOp, *perfMaxBytes)
+	checkRatio(t, "allocs/op", got.AllocsPerOp, base.AllocsPerOp, *perfMaxAllocs)
+}
+
+func TestGenerateUsers(t *testing.T) {
+	f, err := ioutil.TempFile("", "synthetic_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	generateUsers(f, 100, 1)
+	f.Close()
+
+	slowOut, fastOut := new(bytes.Buffer), new(bytes.Buffer)
+	if _, err := SlowSearchWithOptions(slowOut, SearchOptions{Path: f.Name()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FastSearchWithOptions(fastOut, SearchOptions{Path: f.Name()}); err != nil {
+		t.Fatal(err)
+	}
+	if slowOut.String() != fastOut.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", fastOut, slowOut)
+	}
+	if !strings.Contains(fastOut.String(), " [at] ") {
+		t.Errorf("synthetic dataset has no Android and MSIE users:\n%s", fastOut)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	// initial size of scanner buffer, it grows up to SearchOptions.MaxLineSize
+	initialLineSize = 4 * 1024
+	// default max length of one line in users file
+	defaultMaxLineSize = 1024 * 1024
+)
+
+// BadRecordPolicy defines what search does with lines which can't be parsed
+type BadRecordPolicy int
+
+const (
+	// PolicyAbort stops search on the first bad record and returns *RecordError
+	PolicyAbort BadRecordPolicy = iota
+	// PolicySkip skips bad records, only their count is reported
+	PolicySkip
+	// PolicyCollect skips bad records and reports line number and reason for each of them
+	PolicyCollect
+)
+
+// SearchOptions configures SlowSearchWithOptions and FastSearchWithOptions
+type SearchOptions struct {
+	// path to users file, empty means default file, "-" means stdin
+	Path   string
+	Policy BadRecordPolicy
+	// max length of one line in bytes, 0 means defaultMaxLineSize
+	MaxLineSize int
+	// path to index of IndexedSearch, empty means file in cache dir, see IndexPath
+	IndexPath string
+}
+
+func (o SearchOptions) path() string {
+	if o.Path == "" {
+		return filePath
+	}
+	return o.Path
+}
+
+func (o SearchOptions) indexPath() string {
+	if o.IndexPath == "" {
+		return IndexPath(o.path())
+	}
+	return o.IndexPath
+}
+
+func (o SearchOptions) maxLineSize() int {
+	if o.MaxLineSize <= 0 {
+		return defaultMaxLineSize
+	}
+	return o.MaxLineSize
+}
+
+// BadRecord describes skipped line
+type BadRecord struct {
+	Line   int
+	Reason string
+}
+
+// Report contains information about skipped records
+type Report struct {
+	Skipped int
+	Records []BadRecord
+}
+
+// RecordError is returned when record can't be parsed and policy is PolicyAbort
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// Unwrap returns underlying parse error
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// add handles bad record according to policy. It returns error if search must be stopped
+func (r *Report) add(policy BadRecordPolicy, line int, err error) error {
+	switch policy {
+	case PolicySkip:
+		r.Skipped++
+	case PolicyCollect:
+		r.Skipped++
+		r.Records = append(r.Records, BadRecord{Line: line, Reason: err.Error()})
+	default:
+		return &RecordError{Line: line, Err: err}
+	}
+	return nil
+}
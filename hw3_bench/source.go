@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// stdinPath is a path which means reading from stdin
+const stdinPath = "-"
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// source is a decompressed input, closer is nil if decompressor has nothing to release
+type source struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (s *source) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// openFile opens raw file without decompression
+func openFile(path string) (io.ReadCloser, error) {
+	if path == stdinPath {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+func isCompressed(head []byte) bool {
+	return bytes.HasPrefix(head, gzipMagic) || bytes.HasPrefix(head, zstdMagic) || bytes.HasPrefix(head, bzip2Magic)
+}
+
+// NewSource detects compression of r by magic bytes and returns decompressed reader.
+// Closing result doesn't close r.
+func NewSource(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// error is not interesting here, short or empty input is read as is
+	head, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &source{Reader: zr, closer: zr}, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		zrc := zr.IOReadCloser()
+		return &source{Reader: zrc, closer: zrc}, nil
+	case bytes.HasPrefix(head, bzip2Magic):
+		return &source{Reader: bzip2.NewReader(br)}, nil
+	}
+	return &source{Reader: br}, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+)
+
+var (
+	syntheticFirstNames = [...]string{"Sharon", "Susan", "Joshua", "Jonas", "Ann", "Bob", "Maria", "Victor", "Helen", "Peter"}
+	syntheticLastNames  = [...]string{"Crawford", "Ellis", "Fisher", "Carter", "Morris", "Wolf", "Mayer", "Snow", "Lee", "Hart"}
+	syntheticCompanies  = [...]string{"Flashpoint", "Muxo", "Topiczoom", "Voonix", "Yakijo", "Hopeli"}
+	syntheticDomains    = [...]string{"edu", "com", "info", "gov", "org", "net"}
+	syntheticBrowsers   = [...]string{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2227.0 Safari/537.36",
+		"LG-LX550 AU-MIC-LX550/2.0 MMP/2.0 Profile/MIDP-2.0 Configuration/CLDC-1.1",
+		"Mozilla/5.0 (Android; Linux armv7l; rv:10.0.1) Gecko/20100101 Firefox/10.0.1 Fennec/10.0.1",
+		"Mozilla/5.0 (Windows NT 10.0; WOW64; Trident/7.0; MATBJS; rv:11.0) like Gecko",
+		"Mozilla/5.0 (compatible; MSIE 10.0; Windows Phone 8.0; Trident/6.0; IEMobile/10.0; ARM; Touch; NOKIA; Lumia 920)",
+		"Mozilla/4.0 (compatible; MSIE 7.0; Windows Phone OS 7.0; Trident/3.1; IEMobile/7.0)",
+		"Mozilla/5.0 (Linux; Android 4.4.2; LGMS323 Build/KOT49I.MS32310b) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/30.0.1599.103 Mobile Safari/537.36",
+		"Mozilla/5.0 (Windows NT 6.1; Win64; x64; rv:35.0) Gecko/20100101 Firefox/35.0",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_7_5) AppleWebKit/536.26.17 (KHTML like Gecko) Version/6.0.2 Safari/536.26.17",
+		"SonyEricssonW580i/R6BC Browser/NetFront/3.3 Profile/MIDP-2.0 Configuration/CLDC-1.1",
+	}
+)
+
+// syntheticUser has the same fields as users in data/users.txt
+type syntheticUser struct {
+	Browsers []string `json:"browsers"`
+	Company  string   `json:"company"`
+	Country  string   `json:"country"`
+	Email    string   `json:"email"`
+	Job      string   `json:"job"`
+	Name     string   `json:"name"`
+	Phone    string   `json:"phone"`
+}
+
+// generateUsers writes n random users in the format of users file.
+// The same seed gives the same users, so results of benchmarks are comparable.
+func generateUsers(w io.Writer, n int, seed int64) error {
+	rnd := rand.New(rand.NewSource(seed))
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	pick := func(values []string) string {
+		return values[rnd.Intn(len(values))]
+	}
+
+	for i := 0; i < n; i++ {
+		first, last := pick(syntheticFirstNames[:]), pick(syntheticLastNames[:])
+		company := pick(syntheticCompanies[:])
+		user := syntheticUser{
+			Browsers: make([]string, 1+rnd.Intn(5)),
+			Company:  company,
+			Country:  "Dominican Republic",
+			Email:    fmt.Sprintf("%s%s%d@%s.%s", first, last, i, company, pick(syntheticDomains[:])),
+			Job:      "Programmer Analyst #{N}",
+			Name:     first + " " + last,
+			Phone:    fmt.Sprintf("%03d-%02d-%02d", rnd.Intn(1000), rnd.Intn(100), rnd.Intn(100)),
+		}
+		for j := range user.Browsers {
+			// versions make browsers unique like in real data
+			browser := pick(syntheticBrowsers[:])
+			user.Browsers[j] = strings.Replace(browser, "Mozilla/5.0", fmt.Sprintf("Mozilla/5.%d", rnd.Intn(100)), 1)
+		}
+		if err := enc.Encode(user); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
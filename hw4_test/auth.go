@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerAuthorization = "Authorization"
+	// headers of HMAC signature
+	headerSignatureKey       = "X-Signature-Key"
+	headerSignatureTimestamp = "X-Signature-Timestamp"
+	headerSignature          = "X-Signature"
+
+	// token is refreshed a bit earlier than it expires at server
+	tokenExpiryLeeway = 10 * time.Second
+)
+
+// Authenticator adds credentials to request of SearchClient, it is called before every attempt
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// Refresher is Authenticator with expiring credentials.
+// SearchClient calls Invalidate when server responds 401 and repeats request once.
+type Refresher interface {
+	Authenticator
+	Invalidate()
+}
+
+// BearerToken sends static token in Authorization header
+type BearerToken struct {
+	Token string
+}
+
+// Authenticate sets Authorization: Bearer header
+func (a *BearerToken) Authenticate(ctx context.Context, req *http.Request) error {
+	req.Header.Set(headerAuthorization, "Bearer "+a.Token)
+	return nil
+}
+
+// ClientCredentials gets bearer tokens from OAuth2 token endpoint by client credentials grant
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// http client for token endpoint, nil means client with one second timeout
+	HTTPClient *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	// fetching is closed when token request in flight is finished, nil if there is no request
+	fetching chan struct{}
+	// current time, it is replaced in tests
+	now func() time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (a *ClientCredentials) currentTime() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// Authenticate sets bearer token, token is requested when it is missing or expired.
+// Only one token request is sent at a time, concurrent callers wait for its result.
+func (a *ClientCredentials) Authenticate(ctx context.Context, req *http.Request) error {
+	for {
+		a.mu.Lock()
+		if a.token != "" && (a.expires.IsZero() || a.currentTime().Before(a.expires)) {
+			token := a.token
+			a.mu.Unlock()
+			req.Header.Set(headerAuthorization, "Bearer "+token)
+			return nil
+		}
+		if wait := a.fetching; wait != nil {
+			a.mu.Unlock()
+			// the token is checked again, failed request is repeated by one of waiters
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		a.fetching = done
+		a.mu.Unlock()
+
+		token, expires, err := a.fetchToken(ctx)
+
+		a.mu.Lock()
+		if err == nil {
+			a.token, a.expires = token, expires
+		}
+		a.fetching = nil
+		a.mu.Unlock()
+		close(done)
+
+		if err != nil {
+			return err
+		}
+		req.Header.Set(headerAuthorization, "Bearer "+token)
+		return nil
+	}
+}
+
+// Invalidate drops token, the next request gets new one
+func (a *ClientCredentials) Invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+}
+
+// fetchToken requests token from token endpoint, zero expiration time means token doesn't expire
+func (a *ClientCredentials) fetchToken(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	httpClient := a.HTTPClient
+	if httpClient == nil {
+		httpClient = client
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("%w: status %d", ErrTokenRequest, resp.StatusCode)
+	}
+
+	token := tokenResponse{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: cant unpack token json: %v", ErrTokenRequest, err)
+	}
+	if token.AccessToken == "" || (token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer")) {
+		return "", time.Time{}, fmt.Errorf("%w: unsupported token %q", ErrTokenRequest, token.TokenType)
+	}
+
+	var expires time.Time
+	if token.ExpiresIn > 0 {
+		expires = a.currentTime().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryLeeway)
+	}
+	return token.AccessToken, expires, nil
+}
+
+// HMACSigner signs method, path, query and timestamp of request with shared secret
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+
+	// current time, it is replaced in tests
+	now func() time.Time
+}
+
+// signRequest returns hex encoded HMAC-SHA256 of request
+func signRequest(secret []byte, r *http.Request, timestamp string) string {
+	mac := hmac.New(sha256.New, secret)
+	// query is encoded again to be independent from order of parameters
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", r.Method, r.URL.EscapedPath(), r.URL.Query().Encode(), timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate sets key, timestamp and signature headers
+func (a *HMACSigner) Authenticate(ctx context.Context, req *http.Request) error {
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	req.Header.Set(headerSignatureKey, a.KeyID)
+	req.Header.Set(headerSignatureTimestamp, timestamp)
+	req.Header.Set(headerSignature, signRequest(a.Secret, req, timestamp))
+	return nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+)
+
+// BreakerState is a state of CircuitBreaker
+type BreakerState int
+
+const (
+	// StateClosed lets all requests through and counts consecutive failures
+	StateClosed BreakerState = iota
+	// StateOpen rejects requests until OpenTimeout passes
+	StateOpen
+	// StateHalfOpen lets limited count of trial requests through
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStats are counters of CircuitBreaker
+type BreakerStats struct {
+	State BreakerState
+	// requests let through
+	Requests int
+	// successful and failed requests
+	Successes int
+	Failures  int
+	// requests rejected in open and half-open state
+	Rejected int
+	// failures since the last success
+	ConsecutiveFailures int
+}
+
+// CircuitBreaker stops requests to search system after consecutive failures.
+// Timeouts, transport errors and 5xx are failures.
+type CircuitBreaker struct {
+	// consecutive failures which open breaker, 0 means 5
+	FailureThreshold int
+	// time in open state before trial requests, 0 means 30 seconds
+	OpenTimeout time.Duration
+	// trial requests in half-open state, 0 means 1, all of them must succeed to close breaker
+	HalfOpenRequests int
+
+	mu       sync.Mutex
+	stats    BreakerStats
+	openedAt time.Time
+	// trial requests which are sent and succeeded in half-open state
+	trials, trialSuccesses int
+	// generation is changed with state, results of requests allowed in other generation are stale
+	generation uint64
+	// current time, it is replaced in tests
+	now func() time.Time
+}
+
+// NewCircuitBreaker creates closed breaker
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		now:              time.Now,
+	}
+}
+
+func (cb *CircuitBreaker) currentTime() time.Time {
+	if cb.now != nil {
+		return cb.now()
+	}
+	return time.Now()
+}
+
+func (cb *CircuitBreaker) failureThreshold() int {
+	if cb.FailureThreshold <= 0 {
+		return defaultFailureThreshold
+	}
+	return cb.FailureThreshold
+}
+
+func (cb *CircuitBreaker) openTimeout() time.Duration {
+	if cb.OpenTimeout <= 0 {
+		return defaultOpenTimeout
+	}
+	return cb.OpenTimeout
+}
+
+func (cb *CircuitBreaker) halfOpenRequests() int {
+	if cb.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return cb.HalfOpenRequests
+}
+
+// setState changes state, cb.mu must be locked
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	cb.stats.State = state
+	cb.trials, cb.trialSuccesses = 0, 0
+	cb.generation++
+	if state == StateOpen {
+		cb.openedAt = cb.currentTime()
+	}
+}
+
+// allow checks if request can be sent, it returns ErrCircuitOpen otherwise.
+// Returned generation must be passed to done or cancel.
+func (cb *CircuitBreaker) allow() (uint64, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.stats.State == StateOpen && cb.currentTime().Sub(cb.openedAt) >= cb.openTimeout() {
+		cb.setState(StateHalfOpen)
+	}
+	switch cb.stats.State {
+	case StateOpen:
+		cb.stats.Rejected++
+		return 0, ErrCircuitOpen
+	case StateHalfOpen:
+		if cb.trials >= cb.halfOpenRequests() {
+			cb.stats.Rejected++
+			return 0, ErrCircuitOpen
+		}
+		cb.trials++
+	}
+	cb.stats.Requests++
+	return cb.generation, nil
+}
+
+// done records result of request which was allowed in generation.
+// Result of request allowed before state was changed is counted, but doesn't change state.
+func (cb *CircuitBreaker) done(generation uint64, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if generation != cb.generation {
+		if success {
+			cb.stats.Successes++
+		} else {
+			cb.stats.Failures++
+		}
+		return
+	}
+	if success {
+		cb.stats.Successes++
+		cb.stats.ConsecutiveFailures = 0
+		if cb.stats.State == StateHalfOpen {
+			cb.trialSuccesses++
+			if cb.trialSuccesses >= cb.halfOpenRequests() {
+				cb.setState(StateClosed)
+			}
+		}
+		return
+	}
+
+	cb.stats.Failures++
+	cb.stats.ConsecutiveFailures++
+	switch cb.stats.State {
+	case StateHalfOpen:
+		cb.setState(StateOpen)
+	case StateClosed:
+		if cb.stats.ConsecutiveFailures >= cb.failureThreshold() {
+			cb.setState(StateOpen)
+		}
+	}
+}
+
+// cancel releases trial request which was interrupted by caller, it is neither success nor failure
+func (cb *CircuitBreaker) cancel(generation uint64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.stats.Requests--
+	if generation == cb.generation && cb.stats.State == StateHalfOpen && cb.trials > 0 {
+		cb.trials--
+	}
+}
+
+// State returns current state of breaker
+func (cb *CircuitBreaker) State() BreakerState {
+	return cb.Stats().State
+}
+
+// Stats returns counters of breaker
+func (cb *CircuitBreaker) Stats() BreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.stats.State == StateOpen && cb.currentTime().Sub(cb.openedAt) >= cb.openTimeout() {
+		cb.setState(StateHalfOpen)
+	}
+	return cb.stats
+}
+
+// LimiterStats are counters of RateLimiter
+type LimiterStats struct {
+	// requests let through immediately
+	Allowed int
+	// requests let through after wait
+	Delayed int
+	// requests which were cancelled while waiting
+	Cancelled int
+	// total time of waiting
+	Waited time.Duration
+}
+
+// RateLimiter is a token bucket which limits rate of requests of SearchClient
+type RateLimiter struct {
+	// tokens per second, 0 means no limit
+	Rate float64
+	// size of bucket, 0 means 1
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  LimiterStats
+	// current time and sleep, they are replaced in tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) bool
+}
+
+// NewRateLimiter creates limiter with full bucket
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimiter{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+func (l *RateLimiter) currentTime() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// wait sleeps d, it returns false if ctx is done before
+func (l *RateLimiter) wait(ctx context.Context, d time.Duration) bool {
+	if l.sleep != nil {
+		return l.sleep(ctx, d)
+	}
+	return sleepContext(ctx, d)
+}
+
+// reserve takes token, it returns time to wait until token is available
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Rate <= 0 {
+		l.stats.Allowed++
+		return 0
+	}
+	burst := float64(l.Burst)
+	if burst <= 0 {
+		burst = 1
+	}
+	now := l.currentTime()
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.Rate
+		if l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		l.stats.Allowed++
+		return 0
+	}
+	return time.Duration(-l.tokens / l.Rate * float64(time.Second))
+}
+
+// Wait blocks until request is allowed or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	wait := l.reserve()
+	if wait == 0 {
+		return nil
+	}
+	ok := l.wait(ctx, wait)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !ok {
+		// token wasn't used, so it is returned to bucket
+		l.tokens++
+		l.stats.Cancelled++
+		return ctx.Err()
+	}
+	l.stats.Delayed++
+	l.stats.Waited += wait
+	return nil
+}
+
+// Stats returns counters of limiter
+func (l *RateLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
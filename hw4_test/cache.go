@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ResponseCache keeps responses of search system for SearchClient.
+// Fresh responses are returned without request, stale ones with ETag are revalidated by If-None-Match.
+type ResponseCache struct {
+	// time while response is fresh
+	TTL time.Duration
+	// max count of responses, the least recently used ones are evicted, 0 means no limit
+	MaxEntries int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	// current time, it is replaced in tests
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	header  http.Header
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// NewResponseCache creates cache with ttl and size bound
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+func (c *ResponseCache) currentTime() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// lazyInit creates storage of zero value cache, c.mu must be locked
+func (c *ResponseCache) lazyInit() {
+	if c.ll == nil {
+		c.ll = list.New()
+		c.items = make(map[string]*list.Element)
+	}
+}
+
+func cacheKey(accessToken, params string) string {
+	return accessToken + "\x00" + params
+}
+
+// get returns entry by key and flag if it is still fresh
+func (c *ResponseCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	entry := el.Value.(*cacheEntry)
+	return entry, c.currentTime().Before(entry.expires)
+}
+
+// put saves response, entries without ETag are useless after TTL so they are not saved when TTL is 0
+func (c *ResponseCache) put(key string, header http.Header, body []byte) {
+	etag := header.Get("ETag")
+	if c.TTL <= 0 && etag == "" {
+		return
+	}
+	entry := &cacheEntry{
+		key:     key,
+		header:  header,
+		body:    body,
+		etag:    etag,
+		expires: c.currentTime().Add(c.TTL),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lazyInit()
+
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	if c.MaxEntries > 0 && c.ll.Len() > c.MaxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// refresh prolongs entry after successful revalidation
+func (c *ResponseCache) refresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).expires = c.currentTime().Add(c.TTL)
+	}
+}
+
+// Len returns count of cached responses
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ll == nil {
+		return 0
+	}
+	return c.ll.Len()
+}
+
+// Purge removes all responses
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll, c.items = nil, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,7 +10,10 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
+
+	"hw4_test/searchapi"
 )
 
 const (
@@ -22,38 +26,59 @@ var (
 	client  = &http.Client{Timeout: time.Second}
 )
 
-type User struct {
-	Id     int
-	Name   string
-	Age    int
-	About  string
-	Gender string
-}
+const defaultTimeout = time.Second
+
+// User and errors of protocol are shared with fakes of search system
+type User = searchapi.User
 
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// cursor of the next page in cursor mode, empty if there are no more users
+	NextCursor string
+	// highlighted fragments of About by user Id, only for users with matches when SearchRequest.Highlight is set
+	Highlights map[int]string
+	// version of response of search system, 1 is a bare array without Total
+	Version int
+	// count of users matched by query, only for version 2
+	Total int
 }
 
-type SearchErrorResponse struct {
-	Error string
-}
+type SearchErrorResponse = searchapi.ErrorResponse
 
 const (
 	OrderByAsc  = -1
 	OrderByAsIs = 0
 	OrderByDesc = 1
 
-	ErrorBadOrderField = `OrderField invalid`
+	ErrorBadOrderField = searchapi.ErrorBadOrderField
+	ErrorBadCursor     = searchapi.ErrorBadCursor
+	ErrorBadQuery      = searchapi.ErrorBadQuery
+
+	// max Limit which FindUsers sends to search system
+	maxPageSize = 25
+	// header with cursor of the next page in cursor mode
+	headerNextCursor = "X-Next-Cursor"
 )
 
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
-	Query      string // подстрока в 1 из полей
-	OrderField string
+	Query      string // подстрока в 1 из полей, см. query.go
+	OrderField string // Id, Age, Name or Relevance, empty means Name
 	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
 	OrderBy int
+	// cursor mode: users are ordered by (OrderField, Id) and pages are requested by NextCursor
+	// of previous response instead of Offset, so they stay consistent when dataset changes.
+	// UseCursor requests the first page, Cursor requests the next ones.
+	UseCursor bool
+	Cursor    string
+	// request snippets of About with matches of Query, see SearchResponse.Highlights
+	Highlight bool
+}
+
+func (req SearchRequest) cursorMode() bool {
+	return req.UseCursor || req.Cursor != ""
 }
 
 type SearchClient struct {
@@ -61,75 +86,290 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// http client for requests, nil means client with one second timeout
+	HTTPClient *http.Client
+	// transport of default client, it is ignored if HTTPClient is set
+	Transport http.RoundTripper
+	// retries on timeouts and 5xx, zero value means no retries
+	Retry RetryPolicy
+	// cache of responses, nil means no caching
+	Cache *ResponseCache
+	// authentication strategy, nil means AccessToken header
+	Auth Authenticator
+	// format of responses, it is negotiated with search system
+	Encoding Encoding
+	// breaker which stops requests while search system fails, nil means no breaker
+	Breaker *CircuitBreaker
+	// limiter of requests rate, nil means no limit
+	Limiter *RateLimiter
+}
+
+// credentialsKey identifies credentials of client in cache key
+func (srv *SearchClient) credentialsKey() string {
+	if srv.Auth == nil {
+		return srv.AccessToken
+	}
+	return fmt.Sprintf("%T@%p", srv.Auth, srv.Auth)
+}
+
+func (srv *SearchClient) httpClient() *http.Client {
+	switch {
+	case srv.HTTPClient != nil:
+		return srv.HTTPClient
+	case srv.Transport != nil:
+		return &http.Client{Timeout: defaultTimeout, Transport: srv.Transport}
+	}
+	return client
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext is FindUsers which can be cancelled by ctx
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
 		return nil, fmt.Errorf("limit must be > 0")
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+	if req.Limit > maxPageSize {
+		req.Limit = maxPageSize
 	}
 	if req.Offset < 0 {
 		return nil, fmt.Errorf("offset must be > 0")
 	}
+	if _, err := ParseQuery(req.Query); err != nil {
+		return nil, err
+	}
 
-	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
+	if req.cursorMode() {
+		// server returns cursor of the next page, so extra record is not needed
+		if req.Limit == 0 {
+			req.Limit = maxPageSize
+		}
+		searcherParams.Add("cursor", req.Cursor)
+	} else {
+		//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
+		req.Limit++
+		searcherParams.Add("offset", strconv.Itoa(req.Offset))
+	}
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
-	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if req.Highlight {
+		searcherParams.Add("highlight", "1")
+	}
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
-	searcherReq.Header.Add("AccessToken", srv.AccessToken)
-
-	resp, err := client.Do(searcherReq)
+	resp, body, err := srv.doCached(ctx, searcherParams)
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
-		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
 
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
-	case http.StatusBadRequest:
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, &ServerError{StatusCode: resp.StatusCode, Body: body}
+	case resp.StatusCode == http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return nil, fmt.Errorf("cant unpack error json: %w", err)
 		}
-		if errResp.Error == ErrorBadOrderField {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		switch errResp.Error {
+		case ErrorBadOrderField:
+			return nil, &BadOrderFieldError{Field: req.OrderField}
+		case ErrorBadCursor:
+			return nil, ErrBadCursor
+		}
+		if strings.HasPrefix(errResp.Error, ErrorBadQuery) {
+			return nil, fmt.Errorf("%w%s", ErrBadQuery, strings.TrimPrefix(errResp.Error, ErrorBadQuery))
 		}
 		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
 	}
 
-	data := []User{}
-	err = json.Unmarshal(body, &data)
+	env, err := decodeResults(resp.Header.Get("Content-Type"), body)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		return nil, fmt.Errorf("cant unpack result json: %w", err)
 	}
 
-	result := SearchResponse{}
-	if len(data) == req.Limit {
+	data := make([]User, len(env.Users))
+	result := SearchResponse{Version: env.Version, Total: env.Total}
+	for i, r := range env.Users {
+		data[i] = r.User
+		if r.Highlight != "" {
+			if result.Highlights == nil {
+				result.Highlights = make(map[int]string)
+			}
+			result.Highlights[r.Id] = r.Highlight
+		}
+	}
+	if req.cursorMode() {
+		result.Users = data
+		result.NextCursor = env.Page.NextCursor
+		if result.NextCursor == "" {
+			result.NextCursor = resp.Header.Get(headerNextCursor)
+		}
+		result.NextPage = result.NextCursor != ""
+	} else if len(data) == req.Limit {
 		result.NextPage = true
 		result.Users = data[0 : len(data)-1]
+		// the extra record is not a part of page
+		delete(result.Highlights, data[len(data)-1].Id)
 	} else {
 		result.Users = data[0:len(data)]
 	}
 
 	return &result, err
 }
+
+// do sends one request to search system and reads response body.
+// Errors of transport are returned as is to be checked for retry.
+func (srv *SearchClient) do(ctx context.Context, searcherParams url.Values, etag string) (*http.Response, []byte, error) {
+	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	searcherReq = searcherReq.WithContext(ctx)
+	searcherReq.Header.Set("Accept", srv.Encoding.accept())
+	if etag != "" {
+		searcherReq.Header.Set("If-None-Match", etag)
+	}
+
+	// breaker is checked first so rejected requests don't take tokens of limiter,
+	// credentials are added last so rejected requests don't go to token endpoint
+	var generation uint64
+	if srv.Breaker != nil {
+		if generation, err = srv.Breaker.allow(); err != nil {
+			return nil, nil, err
+		}
+	}
+	var resp *http.Response
+	var body []byte
+	if srv.Limiter != nil {
+		err = srv.Limiter.Wait(ctx)
+	}
+	if err == nil {
+		if err = srv.authenticate(ctx, searcherReq); err != nil {
+			// failure of credentials isn't failure of search system
+			if srv.Breaker != nil {
+				srv.Breaker.cancel(generation)
+			}
+			return nil, nil, err
+		}
+		resp, body, err = srv.send(searcherReq)
+	}
+	if srv.Breaker != nil {
+		if ctx.Err() != nil {
+			srv.Breaker.cancel(generation)
+		} else {
+			srv.Breaker.done(generation, err == nil && resp.StatusCode < http.StatusInternalServerError)
+		}
+	}
+	return resp, body, err
+}
+
+// authenticate adds credentials of srv to request
+func (srv *SearchClient) authenticate(ctx context.Context, searcherReq *http.Request) error {
+	if srv.Auth == nil {
+		searcherReq.Header.Add("AccessToken", srv.AccessToken)
+		return nil
+	}
+	return srv.Auth.Authenticate(ctx, searcherReq)
+}
+
+// send sends request and reads response body
+func (srv *SearchClient) send(searcherReq *http.Request) (*http.Response, []byte, error) {
+	resp, err := srv.httpClient().Do(searcherReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// doWithRetry sends request and repeats it on timeouts and 5xx according to srv.Retry
+func (srv *SearchClient) doWithRetry(ctx context.Context, searcherParams url.Values, etag string) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, body, err := srv.do(ctx, searcherParams, etag)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		var retryAfter time.Duration
+		if err != nil {
+			if err == ErrCircuitOpen {
+				return nil, nil, err
+			}
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				return nil, nil, fmt.Errorf("unknown error %w", err)
+			}
+			if attempt >= srv.Retry.MaxRetries {
+				return nil, nil, &TimeoutError{Params: searcherParams.Encode(), Err: err}
+			}
+		} else {
+			if resp.StatusCode < http.StatusInternalServerError || attempt >= srv.Retry.MaxRetries {
+				return resp, body, nil
+			}
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+
+		if !sleepContext(ctx, srv.Retry.delay(attempt, retryAfter)) {
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// doAuthorized sends request and repeats it once with new credentials if they are rejected
+func (srv *SearchClient) doAuthorized(ctx context.Context, searcherParams url.Values, etag string) (*http.Response, []byte, error) {
+	resp, body, err := srv.doWithRetry(ctx, searcherParams, etag)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, body, err
+	}
+	refresher, ok := srv.Auth.(Refresher)
+	if !ok {
+		return resp, body, err
+	}
+	refresher.Invalidate()
+	return srv.doWithRetry(ctx, searcherParams, etag)
+}
+
+// doCached returns response from srv.Cache if it is fresh or not modified, otherwise it sends request
+func (srv *SearchClient) doCached(ctx context.Context, searcherParams url.Values) (*http.Response, []byte, error) {
+	if srv.Cache == nil {
+		return srv.doAuthorized(ctx, searcherParams, "")
+	}
+
+	key := cacheKey(srv.credentialsKey(), searcherParams.Encode())
+	entry, fresh := srv.Cache.get(key)
+	if fresh {
+		return &http.Response{StatusCode: http.StatusOK, Header: entry.header}, entry.body, nil
+	}
+
+	var etag string
+	if entry != nil {
+		etag = entry.etag
+	}
+	resp, body, err := srv.doAuthorized(ctx, searcherParams, etag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		srv.Cache.refresh(key)
+		return &http.Response{StatusCode: http.StatusOK, Header: entry.header}, entry.body, nil
+	case resp.StatusCode == http.StatusOK:
+		srv.Cache.put(key, resp.Header, body)
+	}
+	return resp, body, nil
+}
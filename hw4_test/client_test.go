@@ -1,208 +1,1688 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
-)
-
-type Row struct {
-	XMLName   xml.Name `xml:"row"`
-	ID        int      `xml:"id"`
-	FirstName string   `xml:"first_name"`
-	LastName  string   `xml:"last_name"`
-	Age       int      `xml:"age"`
-	About     string   `xml:"about"`
-	Gender    string   `xml:"gender"`
-}
-
-func (r Row) Name() string {
-	return r.FirstName + " " + r.LastName
-}
-
-type Rows []Row
-
-func loadData(filename string) Rows {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		panic(err)
-	}
-	type Root struct {
-		XMLName xml.Name `xml:"root"`
-		Rows    []Row    `xml:"row"`
-	}
-	a := Root{}
-	if err := xml.Unmarshal(data, &a); err != nil {
-		panic(err)
-	}
-	rows := a.Rows
-	return rows
-}
-
-func SearchServer(params SearchRequest) []User {
-	rows := loadData("dataset.xml")
-	fmt.Printf("%+v\n", params)
-	// search
-	var filtered Rows
-	if params.Query == "" {
-		filtered = rows
-	} else {
-		for i, row := range rows {
-			if strings.Contains(row.Name(), params.Query) || strings.Contains(row.About, params.Query) {
-				filtered = append(filtered, rows[i])
-			}
-		}
-	}
 
-	// order
-
-	// map
-	result := make([]User, len(filtered))
-	for i, r := range filtered {
-		user := User{
-			Id:     r.ID,
-			Name:   r.Name(),
-			Age:    r.Age,
-			Gender: r.Gender,
-			About:  r.About,
-		}
-		result[i] = user
-	}
-
-	fmt.Println("result len:", len(result))
-	return result
-}
-
-func testHandler(w http.ResponseWriter, r *http.Request) {
-	writeResponse := func(w http.ResponseWriter, code int, a interface{}) {
-		w.WriteHeader(code)
-		if a != nil {
-			d, _ := json.Marshal(a)
-			w.Write(d)
-		}
-	}
-
-	test := r.URL.Query().Get("query")
-	switch test {
-	case "token":
-		writeResponse(w, http.StatusUnauthorized, nil)
-	case "bad_order":
-		writeResponse(w, http.StatusBadRequest, SearchErrorResponse{Error: ErrorBadOrderField})
-	case "bad_json":
-		w.WriteHeader(http.StatusOK)
-		io.WriteString(w, `{"status": 400`)
-	case "bad_json2":
-		w.WriteHeader(http.StatusBadRequest)
-		io.WriteString(w, `{"status": 400`)
-	case "bad_request":
-		writeResponse(w, http.StatusBadRequest, SearchErrorResponse{Error: "some error here"})
-	case "internal_error":
-		writeResponse(w, http.StatusInternalServerError, nil)
-	case "timeout":
-		time.Sleep(2 * time.Second)
-		writeResponse(w, http.StatusOK, nil)
-	case "unknown":
-		w.WriteHeader(302)
-		w.Header().Set("Location", "")
-	default:
-		test = "ok"
-	}
-
-	if test != "ok" {
-		return
-	}
-
-	orderField := r.URL.Query().Get("order_field")
-	query := r.URL.Query().Get("query")
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
-	orderStr := r.URL.Query().Get("order_by")
-	limit, _ := strconv.Atoi(limitStr)
-	offset, _ := strconv.Atoi(offsetStr)
-	orderBy, _ := strconv.Atoi(orderStr)
-
-	params := SearchRequest{
-		Limit:      limit,
-		Offset:     offset,
-		Query:      query,
-		OrderField: orderField,
-		OrderBy:    orderBy,
-	}
+	"hw4_test/searchfake"
+)
 
-	// // search
-	users := SearchServer(params)
-	writeResponse(w, http.StatusOK, users)
-}
+const datasetPath = "dataset.xml"
 
 // код писать тут
 func TestSearch(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(testHandler))
-	defer ts.Close()
+	fake := searchfake.NewServer()
+	defer fake.Close()
+	cli := SearchClient{
+		URL:         fake.URL,
+		AccessToken: "secret",
+		HTTPClient:  &http.Client{Timeout: 100 * time.Millisecond},
+	}
+	rose := searchfake.User{Id: 7, Name: "Rose"}
 
 	for _, test := range [...]struct {
 		name   string
 		params SearchRequest
+		resp   searchfake.Response
+		// prefix of expected error, empty means success
+		err string
+		// expected limit of request to search system, empty means no request
+		limit string
 	}{
 		{
 			name:   "limit < 0",
 			params: SearchRequest{Limit: -1},
+			err:    "limit must be > 0",
 		},
 		{
 			name:   "limit > 25",
 			params: SearchRequest{Limit: 30},
+			resp:   searchfake.Users(rose),
+			limit:  "26",
 		},
 		{
 			name:   "offset < 0",
 			params: SearchRequest{Offset: -1},
+			err:    "offset must be > 0",
 		},
 		{
-			name:   "bad token",
-			params: SearchRequest{Query: "token"},
+			name:  "bad token",
+			resp:  searchfake.Unauthorized(),
+			err:   "Bad AccessToken",
+			limit: "1",
 		},
 		{
 			name:   "bad order",
-			params: SearchRequest{Query: "bad_order"},
+			params: SearchRequest{OrderField: "About"},
+			resp:   searchfake.BadOrderField(),
+			err:    "OrderFeld About invalid",
+			limit:  "1",
 		},
 		{
-			name:   "bad json",
-			params: SearchRequest{Query: "bad_json"},
+			name:  "bad json",
+			resp:  searchfake.Malformed(http.StatusOK, `{"status": 400`),
+			err:   "cant unpack result json: ",
+			limit: "1",
 		},
 		{
-			name:   "bad json2",
-			params: SearchRequest{Query: "bad_json2"},
+			name:  "bad json2",
+			resp:  searchfake.Malformed(http.StatusBadRequest, `{"status": 400`),
+			err:   "cant unpack error json: ",
+			limit: "1",
 		},
 		{
-			name:   "internal error",
-			params: SearchRequest{Query: "internal_error"},
+			name:  "internal error",
+			resp:  searchfake.Status(http.StatusInternalServerError),
+			err:   "SearchServer fatal error",
+			limit: "1",
 		},
 		{
-			name:   "bad request",
-			params: SearchRequest{Query: "bad_request"},
+			name:  "bad request",
+			resp:  searchfake.Error(http.StatusBadRequest, "some error here"),
+			err:   "unknown bad request error: some error here",
+			limit: "1",
 		},
 		{
-			name:   "timeout",
-			params: SearchRequest{Query: "timeout"},
+			name:  "timeout",
+			resp:  searchfake.Timeout(time.Second),
+			err:   "timeout for ",
+			limit: "1",
 		},
 		{
 			name:   "test",
-			params: SearchRequest{Query: "Rose"},
+			params: SearchRequest{Query: "Rose", Limit: 5},
+			resp:   searchfake.Users(rose),
+			limit:  "6",
+		},
+		{
+			name:  "unknown",
+			resp:  searchfake.Status(http.StatusFound),
+			err:   "cant unpack result json: ",
+			limit: "1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fake.Reset()
+			fake.Default(test.resp)
+
+			resp, err := cli.FindUsers(test.params)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+				t.Errorf("expected error %q, got %v", test.err, err)
+			case test.err == "" && !reflect.DeepEqual(resp.Users, []User{rose}):
+				t.Errorf("expected %+v, got %+v", rose, resp.Users)
+			}
+
+			requests := fake.Requests()
+			switch {
+			case test.limit == "" && len(requests) != 0:
+				t.Errorf("expected no requests, got %d", len(requests))
+			case test.limit != "" && len(requests) != 1:
+				t.Errorf("expected 1 request, got %d", len(requests))
+			case test.limit != "" && requests[0].Query.Get("limit") != test.limit:
+				t.Errorf("expected limit %s, got %s", test.limit, requests[0].Query.Get("limit"))
+			}
+		})
+	}
+}
+
+func TestSearchFakeAuth(t *testing.T) {
+	fake := searchfake.NewServer()
+	defer fake.Close()
+	fake.On(searchfake.Token("secret"), searchfake.Users(searchfake.User{Id: 1})).Default(searchfake.Unauthorized())
+
+	for name, cli := range map[string]*SearchClient{
+		"access token": {URL: fake.URL, AccessToken: "secret"},
+		"bearer":       {URL: fake.URL, Auth: &BearerToken{Token: "secret"}},
+	} {
+		if resp, err := cli.FindUsers(SearchRequest{Limit: 5}); err != nil || len(resp.Users) != 1 {
+			t.Errorf("[%s] expected user, got %+v, %v", name, resp, err)
+		}
+	}
+	cli := &SearchClient{URL: fake.URL, Auth: &BearerToken{Token: "bad"}}
+	if _, err := cli.FindUsers(SearchRequest{}); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func userIDs(users []User) []int {
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.Id
+	}
+	return ids
+}
+
+func TestSearchServerOrder(t *testing.T) {
+	ts := httptest.NewServer(NewSearchServer(datasetPath, "secret"))
+	defer ts.Close()
+	cli := SearchClient{URL: ts.URL, AccessToken: "secret"}
+
+	for _, test := range [...]struct {
+		name   string
+		params SearchRequest
+		ids    []int
+	}{
+		{
+			name:   "as is",
+			params: SearchRequest{Limit: 3},
+			ids:    []int{0, 1, 2},
+		},
+		{
+			name:   "id desc",
+			params: SearchRequest{Limit: 3, OrderField: "Id", OrderBy: OrderByDesc},
+			ids:    []int{34, 33, 32},
+		},
+		{
+			name:   "age asc with offset",
+			params: SearchRequest{Limit: 2, Offset: 1, OrderField: "Age", OrderBy: OrderByAsc},
+			ids:    []int{15, 23},
+		},
+		{
+			name:   "name by default",
+			params: SearchRequest{Limit: 2, OrderBy: OrderByAsc},
+			ids:    []int{15, 16},
+		},
+		{
+			name:   "query",
+			params: SearchRequest{Limit: 25, Query: "Boyd", OrderField: "Id", OrderBy: OrderByAsc},
+			ids:    []int{0},
 		},
 		{
-			name:   "unknown",
-			params: SearchRequest{Query: "unknown"},
+			name:   "offset out of range",
+			params: SearchRequest{Limit: 5, Offset: 100},
+			ids:    []int{},
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			cli := SearchClient{URL: ts.URL}
-			cli.FindUsers(test.params)
+			resp, err := cli.FindUsers(test.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := userIDs(resp.Users); !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("expected %v, got %v", test.ids, ids)
+			}
+		})
+	}
+}
+
+func TestSearchServerNextPage(t *testing.T) {
+	ts := httptest.NewServer(NewSearchServer(datasetPath, ""))
+	defer ts.Close()
+	cli := SearchClient{URL: ts.URL}
+
+	resp, err := cli.FindUsers(SearchRequest{Limit: 25, Offset: 5})
+	if err != nil || len(resp.Users) != 25 || !resp.NextPage {
+		t.Errorf("expected full page with next one, got %+v, %v", resp, err)
+	}
+	resp, err = cli.FindUsers(SearchRequest{Limit: 25, Offset: 30})
+	if err != nil || len(resp.Users) != 5 || resp.NextPage {
+		t.Errorf("expected last page, got %+v, %v", resp, err)
+	}
+}
+
+func TestSearchServerErrors(t *testing.T) {
+	ts := httptest.NewServer(NewSearchServer(datasetPath, "secret"))
+	defer ts.Close()
+
+	cli := SearchClient{URL: ts.URL, AccessToken: "bad"}
+	if _, err := cli.FindUsers(SearchRequest{}); err == nil || err.Error() != "Bad AccessToken" {
+		t.Errorf("expected bad token error, got %v", err)
+	}
+
+	cli.AccessToken = "secret"
+	if _, err := cli.FindUsers(SearchRequest{OrderField: "About"}); err == nil || err.Error() != "OrderFeld About invalid" {
+		t.Errorf("expected bad order field error, got %v", err)
+	}
+	if _, err := cli.FindUsers(SearchRequest{OrderBy: 2}); err == nil || err.Error() != "unknown bad request error: "+errorBadOrderBy {
+		t.Errorf("expected bad order by error, got %v", err)
+	}
+
+	broken := httptest.NewServer(NewSearchServer("missing.xml", ""))
+	defer broken.Close()
+	cli = SearchClient{URL: broken.URL}
+	if _, err := cli.FindUsers(SearchRequest{}); err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("expected fatal error, got %v", err)
+	}
+}
+
+type countingTransport struct {
+	calls int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.calls++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestFindUsersRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		default:
+			NewSearchServer(datasetPath, "").ServeHTTP(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	transport := &countingTransport{}
+	cli := SearchClient{
+		URL:        ts.URL,
+		HTTPClient: &http.Client{Timeout: 50 * time.Millisecond, Transport: transport},
+		Retry:      RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond},
+	}
+	resp, err := cli.FindUsers(SearchRequest{Limit: 1})
+	if err != nil || len(resp.Users) != 1 {
+		t.Fatalf("expected success after retries, got %+v, %v", resp, err)
+	}
+	if transport.calls != 3 {
+		t.Errorf("expected 3 calls, got %d", transport.calls)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	cli.Retry.MaxRetries = 0
+	if _, err := cli.FindUsers(SearchRequest{}); err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("expected fatal error without retries, got %v", err)
+	}
+}
+
+func TestFindUsersContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	transport := &countingTransport{}
+	cli := SearchClient{URL: ts.URL, Transport: transport, Retry: RetryPolicy{MaxRetries: 5}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cli.FindUsersContext(ctx, SearchRequest{}); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+	if transport.calls != 1 {
+		t.Errorf("expected 1 call with custom transport, got %d", transport.calls)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for attempt, expected := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := p.delay(attempt, 0); d != expected*time.Millisecond {
+			t.Errorf("attempt %d: expected %v, got %v", attempt, expected*time.Millisecond, d)
+		}
+	}
+	if d := p.delay(0, time.Minute); d != time.Minute {
+		t.Errorf("expected Retry-After delay, got %v", d)
+	}
+
+	now := time.Date(2019, 2, 13, 10, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Wed, 13 Feb 2019 10:00:30 GMT": 30 * time.Second,
+		"Wed, 13 Feb 2019 09:00:00 GMT": 0,
+	} {
+		if d := parseRetryAfter(value, now); d != expected {
+			t.Errorf("%q: expected %v, got %v", value, expected, d)
+		}
+	}
+}
+
+func TestFindUsersTypedErrors(t *testing.T) {
+	fake := searchfake.NewServer()
+	defer fake.Close()
+	fake.On(searchfake.Param("query", "token"), searchfake.Unauthorized()).
+		On(searchfake.Param("order_field", "About"), searchfake.BadOrderField()).
+		On(searchfake.Param("query", "internal_error"), searchfake.Status(http.StatusInternalServerError)).
+		On(searchfake.Param("query", "timeout"), searchfake.Timeout(2*time.Second)).
+		On(searchfake.Param("query", "bad_json"), searchfake.Malformed(http.StatusOK, `{"status": 400`)).
+		On(searchfake.Param("cursor", "stale"), searchfake.Error(http.StatusBadRequest, ErrorBadCursor)).
+		On(searchfake.Param("query", "server_bad_query"), searchfake.Error(http.StatusBadRequest, ErrorBadQuery+": unknown field"))
+	cli := SearchClient{URL: fake.URL}
+
+	_, err := cli.FindUsers(SearchRequest{Query: "token"})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+
+	_, err = cli.FindUsers(SearchRequest{OrderField: "About"})
+	orderErr := &BadOrderFieldError{}
+	if !errors.Is(err, ErrBadOrderField) || !errors.As(err, &orderErr) || orderErr.Field != "About" {
+		t.Errorf("expected BadOrderFieldError for About, got %v", err)
+	}
+
+	_, err = cli.FindUsers(SearchRequest{Query: "internal_error"})
+	serverErr := &ServerError{}
+	if !errors.As(err, &serverErr) || serverErr.StatusCode != http.StatusInternalServerError || err.Error() != "SearchServer fatal error" {
+		t.Errorf("expected ServerError, got %v", err)
+	}
+
+	_, err = cli.FindUsers(SearchRequest{Query: "timeout"})
+	timeoutErr := &TimeoutError{}
+	var netErr net.Error
+	if !errors.As(err, &timeoutErr) || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected TimeoutError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "timeout for ") {
+		t.Errorf("unexpected timeout message %q", err)
+	}
+
+	_, err = cli.FindUsers(SearchRequest{Query: "bad_json"})
+	syntaxErr := &json.SyntaxError{}
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("expected wrapped json error, got %v", err)
+	}
+
+	// cursor is checked by server only
+	_, err = cli.FindUsers(SearchRequest{UseCursor: true, Cursor: "stale", Limit: 5})
+	if err != ErrBadCursor {
+		t.Errorf("expected ErrBadCursor, got %v", err)
+	}
+
+	// query which is valid for client can be rejected by server
+	_, err = cli.FindUsers(SearchRequest{Query: "server_bad_query"})
+	if !errors.Is(err, ErrBadQuery) || !strings.HasSuffix(err.Error(), ": unknown field") {
+		t.Errorf("expected ErrBadQuery from server, got %v", err)
+	}
+
+	// cursor mode without limit asks for the max page, there is no extra record
+	fake.Reset()
+	if _, err := cli.FindUsers(SearchRequest{UseCursor: true}); err != nil {
+		t.Fatal(err)
+	}
+	requests := fake.Requests()
+	if len(requests) != 1 || requests[0].Query.Get("limit") != strconv.Itoa(maxPageSize) || requests[0].Query.Get("offset") != "" {
+		t.Errorf("expected cursor request with max limit, got %+v", requests)
+	}
+}
+
+func TestUserIterator(t *testing.T) {
+	var requests int32
+	srv := NewSearchServer(datasetPath, "")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	cli := &SearchClient{URL: ts.URL}
+
+	for _, test := range [...]struct {
+		name     string
+		req      SearchRequest
+		opts     IteratorOptions
+		count    int
+		requests int32
+	}{
+		{"all", SearchRequest{Limit: 10}, IteratorOptions{}, 35, 4},
+		{"all with prefetch", SearchRequest{Limit: 10}, IteratorOptions{Prefetch: true}, 35, 4},
+		{"max results", SearchRequest{Limit: 10}, IteratorOptions{MaxResults: 12, Prefetch: true}, 12, 2},
+		{"offset", SearchRequest{Offset: 30}, IteratorOptions{}, 5, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			users, err := cli.FindAllUsers(context.Background(), test.req, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != test.count {
+				t.Errorf("expected %d users, got %d", test.count, len(users))
+			}
+			if users[0].Id != test.req.Offset || users[len(users)-1].Id != test.req.Offset+test.count-1 {
+				t.Errorf("unexpected users order %v", userIDs(users))
+			}
+			if n := atomic.LoadInt32(&requests); n != test.requests {
+				t.Errorf("expected %d requests, got %d", test.requests, n)
+			}
 		})
 	}
 }
+
+func TestUserIteratorError(t *testing.T) {
+	ts := httptest.NewServer(NewSearchServer(datasetPath, "secret"))
+	defer ts.Close()
+	cli := &SearchClient{URL: ts.URL}
+
+	it := cli.Users(context.Background(), SearchRequest{}, IteratorOptions{})
+	if it.Next() || it.Err() != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", it.Err())
+	}
+
+	cli.AccessToken = "secret"
+	it = cli.Users(context.Background(), SearchRequest{Limit: 5}, IteratorOptions{Prefetch: true})
+	if !it.Next() || it.User().Id != 0 {
+		t.Errorf("expected first user, got %+v, %v", it.User(), it.Err())
+	}
+	it.Close()
+	if it.Next() || it.Err() != nil {
+		t.Errorf("expected end of closed iterator, got %v", it.Err())
+	}
+}
+
+// copyDataset copies dataset.xml to temp file which can be changed by test
+func copyDataset(t *testing.T) string {
+	data, err := ioutil.ReadFile(datasetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "dataset*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(data)
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+// removeRow removes row with id from dataset file
+func removeRow(t *testing.T, path string, id int) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(data)
+	start := strings.Index(s, fmt.Sprintf("<row>\n    <id>%d</id>", id))
+	end := start + strings.Index(s[start:], "</row>") + len("</row>")
+	if err := ioutil.WriteFile(path, []byte(s[:start]+s[end:]), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	path := copyDataset(t)
+	ts := httptest.NewServer(NewSearchServer(path, ""))
+	defer ts.Close()
+	cli := SearchClient{URL: ts.URL}
+
+	req := SearchRequest{Limit: 3, OrderField: "Age", OrderBy: OrderByAsc, UseCursor: true}
+	resp, err := cli.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := userIDs(resp.Users); !reflect.DeepEqual(ids, []int{1, 15, 23}) || !resp.NextPage || resp.NextCursor == "" {
+		t.Fatalf("unexpected first page %v, %+v", ids, resp)
+	}
+
+	// page after cursor doesn't shift when previous rows are removed
+	removeRow(t, path, 1)
+	req.Cursor = resp.NextCursor
+	resp, err = cli.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := userIDs(resp.Users); !reflect.DeepEqual(ids, []int{0, 14, 2}) {
+		t.Errorf("unexpected second page %v", ids)
+	}
+
+	// cursor belongs to search with the same parameters
+	req.Query = "Boyd"
+	if _, err := cli.FindUsers(req); err != ErrBadCursor {
+		t.Errorf("expected ErrBadCursor, got %v", err)
+	}
+	req.Query, req.Cursor = "", "garbage"
+	if _, err := cli.FindUsers(req); err != ErrBadCursor {
+		t.Errorf("expected ErrBadCursor, got %v", err)
+	}
+}
+
+func TestUserIteratorCursor(t *testing.T) {
+	ts := httptest.NewServer(NewSearchServer(datasetPath, ""))
+	defer ts.Close()
+	cli := &SearchClient{URL: ts.URL}
+
+	for _, orderBy := range []int{OrderByAsc, OrderByAsIs, OrderByDesc} {
+		req := SearchRequest{Limit: 4, OrderField: "Name", OrderBy: orderBy}
+		byOffset, err := cli.FindAllUsers(context.Background(), req, IteratorOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		byCursor, err := cli.FindAllUsers(context.Background(), req, IteratorOptions{UseCursor: true, Prefetch: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(userIDs(byOffset), userIDs(byCursor)) {
+			t.Errorf("order %d: pages by offset %v and cursor %v differ", orderBy, userIDs(byOffset), userIDs(byCursor))
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	for query, expected := range map[string]string{
+		"":                                 "<nil>",
+		"Rose":                             `"rose"`,
+		`gender:female age:>30`:            `(gender:"female" AND age:>"30")`,
+		`about:"dolor sit" OR id:<=10`:     `(about:"dolor sit" OR id:<="10")`,
+		`a b OR c`:                         `(("a" AND "b") OR "c")`,
+		`NOT (Name:rose OR age:=20) AND x`: `(NOT (name:"rose" OR age:="20") AND "x")`,
+		`-about:lorem rose`:                `(NOT about:"lorem" AND "rose")`,
+		`- (a OR b) -"x y"`:                `(NOT ("a" OR "b") AND NOT "x y")`,
+		`"10:30" "-1"`:                     `("10:30" AND "-1")`,
+	} {
+		node, err := ParseQuery(query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", query, err)
+			continue
+		}
+		if got := fmt.Sprint(node); got != expected {
+			t.Errorf("%q: expected %s, got %s", query, expected, got)
+		}
+	}
+
+	for query, expected := range map[string]string{
+		`about:"dolor`: "unterminated quote at position 6",
+		`(rose`:        "expected ) at position 5",
+		`email:x`:      "unknown field email at position 0",
+		`name:>x`:      "operator > is allowed only for age and id at position 5",
+		`age:old`:      "age must be int at position 4",
+		`rose OR`:      "unexpected end of query at position 7",
+		`rose)`:        "unexpected ) at position 4",
+		`gender:`:      "expected value of gender at position 7",
+		`AND rose`:     "unexpected AND at position 0",
+		`-email:x`:     "unknown field email at position 1",
+		`10:30`:        "unknown field 10 at position 0",
+		`NOT`:          "unexpected end of query at position 3",
+	} {
+		_, err := ParseQuery(query)
+		if err == nil || err.Error() != expected || !errors.Is(err, ErrBadQuery) {
+			t.Errorf("%q: expected error %q, got %v", query, expected, err)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	users := []User{
+		{Id: 1, Name: "Ann Foo", Age: 20, Gender: "female", About: "meeting at 10:30"},
+		{Id: 2, Name: "Bob Bar", Age: 35, Gender: "male", About: "lorem ipsum"},
+		{Id: 3, Name: "Cid Foo", Age: 40, Gender: "male", About: "dolor (sit)"},
+	}
+	for query, expected := range map[string][]int{
+		`NOT name:foo`:                {2},
+		`-name:foo`:                   {2},
+		`-gender:male`:                {1},
+		`NOT NOT name:foo`:            {1, 3},
+		`foo -age:>30`:                {1},
+		`-(name:bob OR id:1)`:         {3},
+		`NOT about:lorem AND age:>30`: {3},
+		`"10:30"`:                     {1},
+		`"(sit)"`:                     {3},
+	} {
+		node, err := ParseQuery(query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", query, err)
+			continue
+		}
+		got := []int{}
+		for _, u := range users {
+			if node.Match(u) {
+				got = append(got, u.Id)
+			}
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %v, got %v", query, expected, got)
+		}
+	}
+}
+
+func TestSearchServerQuery(t *testing.T) {
+	rows, err := loadData(datasetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{}
+	for _, r := range rows {
+		if r.Gender == "female" && r.Age > 30 && (strings.Contains(r.About, "dolor sit") || r.ID <= 10) {
+			expected = append(expected, r.ID)
+		}
+	}
+
+	ts := httptest.NewServer(NewSearchServer(datasetPath, ""))
+	defer ts.Close()
+	cli := &SearchClient{URL: ts.URL}
+
+	users, err := cli.FindAllUsers(context.Background(), SearchRequest{
+		Query:      `gender:female age:>30 (about:"Dolor Sit" OR id:<=10)`,
+		OrderField: "Id",
+		OrderBy:    OrderByAsc,
+	}, IteratorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := userIDs(users); len(ids) == 0 || !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+
+	// plain query is case insensitive now
+	resp, err := cli.FindUsers(SearchRequest{Query: "boyd WOLF", Limit: 5})
+	if err != nil || len(resp.Users) != 1 || resp.Users[0].Id != 0 {
+		t.Errorf("expected Boyd Wolf, got %+v, %v", resp, err)
+	}
+
+	// text with syntax characters isn't searched as is, it must be quoted
+	if _, err := cli.FindUsers(SearchRequest{Query: "Wolf:", Limit: 5}); !errors.Is(err, ErrBadQuery) {
+		t.Errorf("expected ErrBadQuery for unquoted colon, got %v", err)
+	}
+	resp, err = cli.FindUsers(SearchRequest{Query: `-"boyd wolf"`, Limit: 5, OrderField: "Id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := userIDs(resp.Users); !reflect.DeepEqual(ids, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("expected users except Boyd Wolf, got %v", ids)
+	}
+
+	// client validates query before request
+	if _, err := cli.FindUsers(SearchRequest{Query: "age:>"}); !errors.Is(err, ErrBadQuery) {
+		t.Errorf("expected ErrBadQuery, got %v", err)
+	}
+
+	// server rejects malformed query with 400
+	r, err := http.Get(ts.URL + "?query=" + url.QueryEscape("(rose"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	errResp := SearchErrorResponse{}
+	json.NewDecoder(r.Body).Decode(&errResp)
+	if r.StatusCode != http.StatusBadRequest || errResp.Error != "Query invalid: expected ) at position 5" {
+		t.Errorf("unexpected response %d %+v", r.StatusCode, errResp)
+	}
+}
+
+func TestFindUsersCache(t *testing.T) {
+	statuses := make([]int, 0)
+	srv := NewSearchServer(datasetPath, "")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, r)
+		statuses = append(statuses, rec.Code)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	defer ts.Close()
+
+	now := time.Date(2019, 2, 13, 10, 0, 0, 0, time.UTC)
+	cache := NewResponseCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+	cli := &SearchClient{URL: ts.URL, Cache: cache}
+
+	req := SearchRequest{Limit: 5, UseCursor: true}
+	first, err := cli.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fresh response is returned without request
+	second, err := cli.FindUsers(req)
+	if err != nil || !reflect.DeepEqual(first, second) || len(statuses) != 1 {
+		t.Errorf("expected cached response, got %+v, %v, requests %v", second, err, statuses)
+	}
+
+	// stale response is revalidated
+	now = now.Add(2 * time.Minute)
+	third, err := cli.FindUsers(req)
+	if err != nil || !reflect.DeepEqual(first, third) || !reflect.DeepEqual(statuses, []int{200, 304}) {
+		t.Errorf("expected revalidated response, got %+v, %v, requests %v", third, err, statuses)
+	}
+	if third.NextCursor == "" {
+		t.Error("expected cursor header from cache")
+	}
+
+	// token is a part of key, the oldest entries are evicted
+	cli.AccessToken = "other"
+	cli.FindUsers(req)
+	cli.FindUsers(SearchRequest{Limit: 1})
+	if cache.Len() != 2 || len(statuses) != 4 {
+		t.Errorf("expected 2 entries and 4 requests, got %d and %v", cache.Len(), statuses)
+	}
+	cli.AccessToken = ""
+	cli.FindUsers(req)
+	if len(statuses) != 5 || statuses[4] != http.StatusOK {
+		t.Errorf("expected evicted entry to be requested again, got %v", statuses)
+	}
+
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Errorf("expected empty cache, got %d", cache.Len())
+	}
+}
+
+func TestResponseCacheZeroValue(t *testing.T) {
+	ts := httptest.NewServer(NewSearchServer(datasetPath, ""))
+	defer ts.Close()
+
+	cache := &ResponseCache{TTL: time.Minute}
+	if cache.Len() != 0 {
+		t.Errorf("expected empty cache, got %d", cache.Len())
+	}
+	cli := &SearchClient{URL: ts.URL, Cache: cache}
+	for i := 0; i < 2; i++ {
+		if _, err := cli.FindUsers(SearchRequest{Limit: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cache.Len() != 1 {
+		t.Errorf("expected cached response, got %d entries", cache.Len())
+	}
+	cache.Purge()
+	if _, err := cli.FindUsers(SearchRequest{Limit: 5}); err != nil || cache.Len() != 1 {
+		t.Errorf("expected cache to be used after purge, got %d entries, %v", cache.Len(), err)
+	}
+}
+
+func TestEtagMatch(t *testing.T) {
+	for header, expected := range map[string]bool{
+		``:             false,
+		`"abc"`:        true,
+		`"x", W/"abc"`: true,
+		`*`:            true,
+		`"abcd"`:       false,
+	} {
+		if etagMatch(header, `"abc"`) != expected {
+			t.Errorf("%q: expected %v", header, expected)
+		}
+	}
+}
+
+func TestFederatedClient(t *testing.T) {
+	// backends share most of users, each one misses some of them
+	backends := make([]*SearchClient, 0)
+	for _, missing := range [][]int{{0, 14}, {1, 15}} {
+		path := copyDataset(t)
+		for _, id := range missing {
+			removeRow(t, path, id)
+		}
+		ts := httptest.NewServer(NewSearchServer(path, ""))
+		defer ts.Close()
+		backends = append(backends, &SearchClient{URL: ts.URL})
+	}
+	fc := NewFederatedClient(backends...)
+
+	resp, err := fc.FindUsers(SearchRequest{Limit: 6, OrderField: orderFieldAge, OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := userIDs(resp.Users); !reflect.DeepEqual(ids, []int{1, 15, 23, 0, 14, 2}) || !resp.NextPage || len(resp.Errors) != 0 {
+		t.Errorf("unexpected merged page: %v, %+v", ids, resp)
+	}
+
+	// pages of merged stream contain every user once
+	seen := make(map[int]bool)
+	req := SearchRequest{Limit: 10, OrderField: orderFieldName, OrderBy: OrderByDesc}
+	for {
+		resp, err := fc.FindUsers(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range resp.Users {
+			if seen[u.Id] {
+				t.Errorf("duplicated user %d at offset %d", u.Id, req.Offset)
+			}
+			seen[u.Id] = true
+		}
+		if !resp.NextPage {
+			break
+		}
+		req.Offset += req.Limit
+	}
+	if len(seen) != 35 {
+		t.Errorf("expected 35 users, got %d", len(seen))
+	}
+
+	// failed backend gives partial result
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failed.Close()
+	fc.Clients = append(fc.Clients, &SearchClient{URL: failed.URL})
+	resp, err = fc.FindUsers(SearchRequest{Limit: 3, OrderField: orderFieldID, OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverErr := &ServerError{}
+	if ids := userIDs(resp.Users); !reflect.DeepEqual(ids, []int{0, 1, 2}) || len(resp.Errors) != 1 ||
+		resp.Errors[0].URL != failed.URL || !errors.As(resp.Errors[0], &serverErr) {
+		t.Errorf("unexpected partial result: %v, %v", ids, resp.Errors)
+	}
+
+	// all backends failed
+	fc.Clients = fc.Clients[2:]
+	if _, err := fc.FindUsers(SearchRequest{Limit: 3}); !errors.Is(err, errAllBackendsFailed) {
+		t.Errorf("expected errAllBackendsFailed, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		fc  *FederatedClient
+		req SearchRequest
+		err error
+	}{
+		{NewFederatedClient(), SearchRequest{}, errNoBackends},
+		{fc, SearchRequest{UseCursor: true}, errFederatedCursor},
+		{fc, SearchRequest{OrderField: "About"}, ErrBadOrderField},
+	} {
+		if _, err := tc.fc.FindUsers(tc.req); !errors.Is(err, tc.err) {
+			t.Errorf("%+v: expected %v, got %v", tc.req, tc.err, err)
+		}
+	}
+}
+
+func TestDatasetLoaders(t *testing.T) {
+	rows, err := loadData(datasetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = rows[:5]
+	for i := range rows {
+		rows[i].XMLName = xml.Name{}
+	}
+
+	dir, err := ioutil.TempDir("", "datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, _ := json.Marshal(rows)
+	jsonPath := filepath.Join(dir, "users.json")
+	ioutil.WriteFile(jsonPath, data, 0644)
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"gender", "id", "first_name", "last_name", "age", "about", "company"})
+	for _, r := range rows {
+		w.Write([]string{r.Gender, strconv.Itoa(r.ID), r.FirstName, r.LastName, strconv.Itoa(r.Age), r.About, "ACME"})
+	}
+	w.Flush()
+	csvPath := filepath.Join(dir, "users.csv")
+	ioutil.WriteFile(csvPath, buf.Bytes(), 0644)
+
+	for _, path := range []string{jsonPath, csvPath} {
+		loaded, err := loadData(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(loaded, rows) {
+			t.Errorf("%s: expected %+v, got %+v", path, rows, loaded)
+		}
+	}
+
+	for _, data := range []string{
+		"first_name\nBoyd\n",
+		"id,age\n1,old\n",
+		"id\nx\n",
+	} {
+		if _, err := LoadCSV(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+}
+
+func TestFileDatasetReload(t *testing.T) {
+	path := copyDataset(t)
+	d := NewFileDataset(path)
+	first, err := d.Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := d.Rows()
+	if len(first) != 35 || &first[0] != &second[0] {
+		t.Errorf("expected dataset to be loaded once")
+	}
+
+	removeRow(t, path, 0)
+	third, err := d.Rows()
+	if err != nil || len(third) != 34 {
+		t.Errorf("expected reloaded dataset, got %d rows, %v", len(third), err)
+	}
+
+	// broken file isn't cached, the last loaded rows are served
+	ioutil.WriteFile(path, []byte("<root>"), 0644)
+	if rows, err := d.Rows(); err != nil || len(rows) != 34 {
+		t.Errorf("expected last loaded rows on broken dataset, got %d rows, %v", len(rows), err)
+	}
+	if d.LastError() == nil {
+		t.Error("expected error of broken dataset")
+	}
+	os.Remove(path)
+	if rows, err := d.Rows(); err != nil || len(rows) != 34 {
+		t.Errorf("expected last loaded rows on missing dataset, got %d rows, %v", len(rows), err)
+	}
+	if d.LastError() == nil {
+		t.Error("expected error of missing dataset")
+	}
+
+	// reload is retried and clears error
+	restored := copyDataset(t)
+	if err := os.Rename(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	if rows, err := d.Rows(); err != nil || len(rows) != 35 || d.LastError() != nil {
+		t.Errorf("expected restored dataset, got %d rows, %v, %v", len(rows), err, d.LastError())
+	}
+
+	// file which was never loaded is an error
+	if _, err := NewFileDataset(path + ".missing").Rows(); err == nil {
+		t.Error("expected error of missing dataset")
+	}
+}
+
+func TestFileDatasetBrokenVersion(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	path := copyDataset(t)
+	loads := 0
+	d := NewFileDataset(path)
+	d.Loader = func(r io.Reader) (Rows, error) {
+		loads++
+		return LoadXML(r)
+	}
+	if _, err := d.Rows(); err != nil {
+		t.Fatal(err)
+	}
+
+	// broken version is loaded and logged once
+	ioutil.WriteFile(path, []byte("<root>"), 0644)
+	for i := 0; i < 3; i++ {
+		if rows, err := d.Rows(); err != nil || len(rows) != 35 {
+			t.Errorf("expected last loaded rows, got %d rows, %v", len(rows), err)
+		}
+	}
+	if loads != 2 || strings.Count(logs.String(), "reload failed") != 1 {
+		t.Errorf("expected broken file to be loaded and logged once, got %d loads, logs %q", loads, logs)
+	}
+
+	// changed file is loaded again
+	ioutil.WriteFile(path, []byte("<root><row>"), 0644)
+	d.Rows()
+	d.Rows()
+	if loads != 3 || strings.Count(logs.String(), "reload failed") != 2 {
+		t.Errorf("expected changed file to be loaded once, got %d loads, logs %q", loads, logs)
+	}
+
+	// file which was never loaded returns error without reload
+	loads = 0
+	broken := NewFileDataset(path)
+	broken.Loader = d.Loader
+	for i := 0; i < 2; i++ {
+		if _, err := broken.Rows(); err == nil {
+			t.Error("expected error of broken dataset")
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected broken file to be loaded once, got %d", loads)
+	}
+}
+
+func TestSearchServerMemoryDataset(t *testing.T) {
+	fixture := MemoryDataset{
+		{ID: 3, FirstName: "Ann", Age: 30},
+		{ID: 1, FirstName: "Bob", Age: 20},
+		{ID: 2, FirstName: "Cid", Age: 25},
+	}
+	srv := &SearchServer{Dataset: fixture}
+	users, _, err := srv.Search(SearchRequest{OrderField: orderFieldAge, OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := userIDs(users); !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("expected ordered users, got %v", ids)
+	}
+	// shared rows keep their order
+	if fixture[0].ID != 3 || fixture[1].ID != 1 {
+		t.Errorf("dataset is modified by search: %+v", fixture)
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	search := NewSearchServer(datasetPath, "")
+	issuer := NewTokenIssuer(map[string]string{"app": "s3cret:/"}, time.Minute)
+	var tokenRequests int32
+	mux := http.NewServeMux()
+	mux.Handle("/token", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		issuer.ServeHTTP(w, r)
+	}))
+	mux.Handle("/bearer", RequireAuth(&BearerVerifier{Tokens: []string{"static"}}, search))
+	mux.Handle("/oauth", RequireAuth(issuer, search))
+	mux.Handle("/hmac", RequireAuth(&HMACVerifier{Keys: map[string][]byte{"k1": []byte("key")}}, search))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	find := func(path string, auth Authenticator) error {
+		cli := &SearchClient{URL: ts.URL + path, Auth: auth}
+		_, err := cli.FindUsers(SearchRequest{Limit: 1, Query: "age:>30"})
+		return err
+	}
+
+	// static bearer token
+	if err := find("/bearer", &BearerToken{Token: "static"}); err != nil {
+		t.Errorf("bearer: %v", err)
+	}
+	if err := find("/bearer", &BearerToken{Token: "other"}); err != ErrUnauthorized {
+		t.Errorf("bearer: expected ErrUnauthorized, got %v", err)
+	}
+	if err := find("/bearer", nil); err != ErrUnauthorized {
+		t.Errorf("bearer: expected ErrUnauthorized without credentials, got %v", err)
+	}
+
+	// client credentials, token is reused and refreshed on 401 and expiration
+	now := time.Now()
+	cc := &ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "app", ClientSecret: "s3cret:/"}
+	cc.now = func() time.Time { return now }
+	for i, expected := range []int32{1, 1} {
+		if err := find("/oauth", cc); err != nil || atomic.LoadInt32(&tokenRequests) != expected {
+			t.Errorf("oauth request %d: %v, token requests %d", i, err, tokenRequests)
+		}
+	}
+	issuer.Revoke()
+	if err := find("/oauth", cc); err != nil || atomic.LoadInt32(&tokenRequests) != 2 {
+		t.Errorf("oauth after revoke: %v, token requests %d", err, tokenRequests)
+	}
+	now = now.Add(time.Minute)
+	if err := find("/oauth", cc); err != nil || atomic.LoadInt32(&tokenRequests) != 3 {
+		t.Errorf("oauth after expiration: %v, token requests %d", err, tokenRequests)
+	}
+	bad := &ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "app", ClientSecret: "wrong"}
+	if err := find("/oauth", bad); !errors.Is(err, ErrTokenRequest) {
+		t.Errorf("oauth: expected ErrTokenRequest, got %v", err)
+	}
+
+	// hmac signature
+	signer := &HMACSigner{KeyID: "k1", Secret: []byte("key")}
+	if err := find("/hmac", signer); err != nil {
+		t.Errorf("hmac: %v", err)
+	}
+	if err := find("/hmac", &HMACSigner{KeyID: "k1", Secret: []byte("other")}); err != ErrUnauthorized {
+		t.Errorf("hmac: expected ErrUnauthorized for wrong secret, got %v", err)
+	}
+	signer.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := find("/hmac", signer); err != ErrUnauthorized {
+		t.Errorf("hmac: expected ErrUnauthorized for old signature, got %v", err)
+	}
+}
+
+func TestClientCredentialsSingleFetch(t *testing.T) {
+	issuer := NewTokenIssuer(map[string]string{"app": "secret"}, time.Minute)
+	var tokenRequests int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		<-release
+		issuer.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	cc := &ClientCredentials{TokenURL: ts.URL, ClientID: "app", ClientSecret: "secret"}
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if err := cc.Authenticate(context.Background(), req); err != nil {
+				errs <- err
+				return
+			}
+			errs <- issuer.Verify(req)
+		}()
+	}
+
+	// lock isn't held while token is requested
+	for atomic.LoadInt32(&tokenRequests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cc.Invalidate()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := cc.Authenticate(ctx, httptest.NewRequest(http.MethodGet, "/", nil)); err != context.DeadlineExceeded {
+		t.Errorf("expected waiting for token request, got %v", err)
+	}
+
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Errorf("expected one token request, got %d", n)
+	}
+}
+
+func TestTokenIssuerZeroValue(t *testing.T) {
+	issuer := &TokenIssuer{Clients: map[string]string{"app": "secret"}}
+	ts := httptest.NewServer(issuer)
+	defer ts.Close()
+
+	cc := &ClientCredentials{TokenURL: ts.URL, ClientID: "app", ClientSecret: "secret"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := cc.Authenticate(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if err := issuer.Verify(req); err != nil {
+		t.Errorf("expected issued token to be valid, got %v", err)
+	}
+}
+
+func TestAuthAfterBreaker(t *testing.T) {
+	issuer := NewTokenIssuer(map[string]string{"app": "secret"}, time.Minute)
+	var tokenRequests int32
+	mux := http.NewServeMux()
+	mux.Handle("/token", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		issuer.ServeHTTP(w, r)
+	}))
+	mux.Handle("/search", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cc := &ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "app", ClientSecret: "secret"}
+	cli := &SearchClient{URL: ts.URL + "/search", Auth: cc, Breaker: NewCircuitBreaker(1, time.Minute)}
+	cli.FindUsers(SearchRequest{Limit: 1})
+	cc.Invalidate()
+	if _, err := cli.FindUsers(SearchRequest{Limit: 1}); err != ErrCircuitOpen {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Errorf("expected no token requests while breaker is open, got %d", n)
+	}
+
+	// failed token request isn't failure of search system
+	bad := &ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "app", ClientSecret: "wrong"}
+	breaker := NewCircuitBreaker(1, time.Minute)
+	cli = &SearchClient{URL: ts.URL + "/search", Auth: bad, Breaker: breaker}
+	if _, err := cli.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrTokenRequest) {
+		t.Errorf("expected ErrTokenRequest, got %v", err)
+	}
+	if stats := breaker.Stats(); stats.State != StateClosed || stats.Requests != 0 || stats.Failures != 0 {
+		t.Errorf("failed token request is counted by breaker: %+v", stats)
+	}
+}
+
+func TestTokenIssuerPrune(t *testing.T) {
+	now := time.Now()
+	issuer := NewTokenIssuer(map[string]string{"app": "secret"}, time.Minute)
+	issuer.now = func() time.Time { return now }
+	issue := func() {
+		r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader("grant_type=client_credentials"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth("app", "secret")
+		w := httptest.NewRecorder()
+		issuer.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected token, got %d", w.Code)
+		}
+	}
+
+	issue()
+	issue()
+	now = now.Add(time.Minute)
+	issue()
+	if len(issuer.tokens) != 1 {
+		t.Errorf("expected expired tokens to be removed, got %d tokens", len(issuer.tokens))
+	}
+}
+
+func TestHMACVerifierTampering(t *testing.T) {
+	signer := &HMACSigner{KeyID: "k1", Secret: []byte("key")}
+	v := &HMACVerifier{Keys: map[string][]byte{"k1": []byte("key")}}
+
+	req := httptest.NewRequest(http.MethodGet, "/?limit=2&query=rose", nil)
+	signer.Authenticate(context.Background(), req)
+	if err := v.Verify(req); err != nil {
+		t.Fatal(err)
+	}
+	req.URL.RawQuery = "limit=20&query=rose"
+	if err := v.Verify(req); err != errBadSignature {
+		t.Errorf("expected errBadSignature for changed query, got %v", err)
+	}
+	req.Header.Set(headerSignatureKey, "k2")
+	if err := v.Verify(req); err != errBadSignature {
+		t.Errorf("expected errBadSignature for unknown key, got %v", err)
+	}
+	req.Header.Del(headerSignature)
+	if err := v.Verify(req); err != errNoCredentials {
+		t.Errorf("expected errNoCredentials, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var hits int32
+	search := NewSearchServer(datasetPath, "")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		search.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+	cli := &SearchClient{URL: ts.URL, Breaker: breaker}
+	req := SearchRequest{Limit: 1}
+
+	// failures open breaker, then requests are rejected without sending
+	for i := 0; i < 2; i++ {
+		if _, err := cli.FindUsers(req); !errors.As(err, new(*ServerError)) {
+			t.Errorf("request %d: expected ServerError, got %v", i, err)
+		}
+	}
+	if _, err := cli.FindUsers(req); err != ErrCircuitOpen || atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("expected ErrCircuitOpen without request, got %v, %d requests", err, hits)
+	}
+	if breaker.State() != StateOpen {
+		t.Errorf("expected open breaker, got %s", breaker.State())
+	}
+
+	// failed trial request opens breaker again
+	now = now.Add(time.Minute)
+	if breaker.State() != StateHalfOpen {
+		t.Errorf("expected half-open breaker, got %s", breaker.State())
+	}
+	cli.FindUsers(req)
+	if breaker.State() != StateOpen || atomic.LoadInt32(&hits) != 3 {
+		t.Errorf("expected open breaker after failed trial, got %s, %d requests", breaker.State(), hits)
+	}
+
+	// successful trial request closes breaker
+	now = now.Add(time.Minute)
+	atomic.StoreInt32(&failing, 0)
+	if _, err := cli.FindUsers(req); err != nil {
+		t.Fatal(err)
+	}
+	expected := BreakerStats{State: StateClosed, Requests: 4, Successes: 1, Failures: 3, Rejected: 1}
+	if stats := breaker.Stats(); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	// cancelled request is not a failure
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cli.FindUsersContext(ctx, req)
+	if stats := breaker.Stats(); stats.Failures != 3 || stats.Requests != 4 {
+		t.Errorf("cancelled request is counted: %+v", stats)
+	}
+}
+
+func TestBreakerStaleResults(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	// request is allowed in closed state and finishes after breaker is opened by another one
+	slow, _ := breaker.allow()
+	failed, _ := breaker.allow()
+	breaker.done(failed, false)
+	now = now.Add(time.Minute)
+	if breaker.State() != StateHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", breaker.State())
+	}
+	breaker.done(slow, true)
+	if breaker.State() != StateHalfOpen {
+		t.Errorf("stale success closes breaker: %s", breaker.State())
+	}
+
+	// trial is still available and its result changes state
+	trial, err := breaker.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaker.cancel(slow)
+	if _, err := breaker.allow(); err != ErrCircuitOpen {
+		t.Errorf("stale cancel releases trial, got %v", err)
+	}
+	breaker.done(trial, true)
+	expected := BreakerStats{State: StateClosed, Requests: 2, Successes: 2, Failures: 1, Rejected: 1}
+	if stats := breaker.Stats(); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	// stale failure doesn't open breaker
+	breaker.done(failed, false)
+	if breaker.State() != StateClosed {
+		t.Errorf("stale failure opens breaker: %s", breaker.State())
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	var waits []time.Duration
+	limiter := NewRateLimiter(10, 2)
+	limiter.last = now
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(ctx context.Context, d time.Duration) bool {
+		waits = append(waits, d)
+		now = now.Add(d)
+		return true
+	}
+
+	// burst of 2 requests, the others wait for 100ms each
+	for i := 0; i < 4; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []time.Duration{100 * time.Millisecond, 100 * time.Millisecond}; !reflect.DeepEqual(waits, expected) {
+		t.Errorf("expected waits %v, got %v", expected, waits)
+	}
+	expected := LimiterStats{Allowed: 2, Delayed: 2, Waited: 200 * time.Millisecond}
+	if stats := limiter.Stats(); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	// bucket is refilled with time
+	now = now.Add(time.Second)
+	waits = nil
+	limiter.Wait(context.Background())
+	limiter.Wait(context.Background())
+	if len(waits) != 0 {
+		t.Errorf("expected refilled bucket, got waits %v", waits)
+	}
+
+	// client waits for limiter
+	ts := httptest.NewServer(NewSearchServer(datasetPath, ""))
+	defer ts.Close()
+	cli := &SearchClient{URL: ts.URL, Limiter: limiter}
+	if _, err := cli.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := limiter.Stats(); stats.Delayed != 3 {
+		t.Errorf("expected delayed request of client, got %+v", stats)
+	}
+
+	// waiting is interrupted by context
+	slow := NewRateLimiter(0.1, 1)
+	slow.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := slow.Wait(ctx); err != context.DeadlineExceeded || slow.Stats().Cancelled != 1 {
+		t.Errorf("expected cancelled wait, got %v, %+v", err, slow.Stats())
+	}
+}
+
+func TestRelevanceAndHighlight(t *testing.T) {
+	srv := &SearchServer{Dataset: MemoryDataset{
+		{ID: 1, FirstName: "Ann", LastName: "Rose", About: "Rose"},
+		{ID: 2, FirstName: "Bob", About: "rose rose rose rose rose"},
+		{ID: 3, FirstName: "Cid", About: "nothing"},
+		{ID: 4, FirstName: "Dan", About: strings.Repeat("long text ", 10) + "a rose" + strings.Repeat(" and more", 10)},
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	cli := &SearchClient{URL: ts.URL}
+
+	for _, tc := range []struct {
+		orderBy  int
+		expected []int
+	}{
+		{OrderByAsIs, []int{2, 1, 4}},
+		{OrderByAsc, []int{2, 1, 4}},
+		{OrderByDesc, []int{4, 1, 2}},
+	} {
+		users, _, err := srv.Search(SearchRequest{Query: "rose", OrderField: orderFieldRelevance, OrderBy: tc.orderBy})
+		if ids := userIDs(users); err != nil || !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("order by %d: expected %v, got %v, %v", tc.orderBy, tc.expected, ids, err)
+		}
+	}
+
+	resp, err := cli.FindUsers(SearchRequest{Limit: 2, Query: "rose", OrderField: orderFieldRelevance, Highlight: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]string{
+		2: "<em>rose</em> <em>rose</em> <em>rose</em> <em>rose</em> <em>rose</em>",
+		1: "<em>Rose</em>",
+	}
+	if ids := userIDs(resp.Users); !reflect.DeepEqual(ids, []int{2, 1}) || !reflect.DeepEqual(resp.Highlights, expected) {
+		t.Errorf("unexpected response %v, %v", ids, resp.Highlights)
+	}
+
+	// terms of name are not highlighted
+	resp, err = cli.FindUsers(SearchRequest{Limit: 5, Query: "name:ann", Highlight: true})
+	if err != nil || len(resp.Users) != 1 || resp.Highlights != nil {
+		t.Errorf("expected no highlights, got %+v, %v", resp, err)
+	}
+
+	// cursor pages keep relevance order
+	users, err := cli.FindAllUsers(context.Background(), SearchRequest{Limit: 1, Query: "rose", OrderField: orderFieldRelevance}, IteratorOptions{UseCursor: true})
+	if ids := userIDs(users); err != nil || !reflect.DeepEqual(ids, []int{2, 1, 4}) {
+		t.Errorf("expected relevance order by cursor, got %v, %v", ids, err)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	query, _ := ParseQuery(`about:rose OR "dolor sit" NOT lorem`)
+	h := newHighlighter(query)
+	text := strings.Repeat("x", 50) + " Dolor sit amet, rose " + strings.Repeat("y", 50)
+	expected := "..." + strings.Repeat("x", 39) + " <em>Dolor sit</em> amet, <em>rose</em> " + strings.Repeat("y", 28) + "..."
+	if s := h.snippet(text); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+	if s := h.snippet("lorem ipsum"); s != "" {
+		t.Errorf("expected empty snippet, got %q", s)
+	}
+	if newHighlighter(nil) != nil {
+		t.Error("expected no highlighter without query")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		accept    string
+		mediaType string
+		ok        bool
+	}{
+		{"", mediaTypeV1, true},
+		{"*/*", mediaTypeV1, true},
+		{"application/json", mediaTypeV1, true},
+		{mediaTypeV2JSON, mediaTypeV2JSON, true},
+		{EncodingMsgpack.accept(), mediaTypeV2Msgpack, true},
+		{mediaTypeV2Msgpack + ";q=0.5, " + mediaTypeV2JSON, mediaTypeV2JSON, true},
+		{"text/html, " + mediaTypeV2JSON + ";q=0", "", false},
+		{"text/html, */*;q=0.1", mediaTypeV1, true},
+	} {
+		mediaType, ok := negotiate(tc.accept)
+		if mediaType != tc.mediaType || ok != tc.ok {
+			t.Errorf("%q: expected %q, %v, got %q, %v", tc.accept, tc.mediaType, tc.ok, mediaType, ok)
+		}
+	}
+}
+
+func TestResponseEncodings(t *testing.T) {
+	ts := httptest.NewServer(NewSearchServer(datasetPath, ""))
+	defer ts.Close()
+
+	req := SearchRequest{Limit: 3, Offset: 2, Query: "gender:female about:dolor", OrderField: orderFieldAge, OrderBy: OrderByAsc, Highlight: true}
+	all, _, err := NewSearchServer(datasetPath, "").Search(SearchRequest{Query: req.Query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := (&SearchClient{URL: ts.URL, Encoding: EncodingV1}).FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected.Version != 1 || len(expected.Users) != 3 || !expected.NextPage || len(expected.Highlights) != 3 {
+		t.Fatalf("unexpected v1 response %+v", expected)
+	}
+
+	for _, enc := range []Encoding{EncodingJSON, EncodingMsgpack} {
+		resp, err := (&SearchClient{URL: ts.URL, Encoding: enc}).FindUsers(req)
+		if err != nil {
+			t.Errorf("encoding %d: %v", enc, err)
+			continue
+		}
+		if resp.Version != 2 || resp.Total != len(all) {
+			t.Errorf("encoding %d: expected version 2 with total, got %d, %d", enc, resp.Version, resp.Total)
+		}
+		resp.Version, resp.Total = expected.Version, expected.Total
+		if !reflect.DeepEqual(resp, expected) {
+			t.Errorf("encoding %d: expected %+v, got %+v", enc, expected, resp)
+		}
+	}
+
+	// cursor is in envelope too
+	resp, err := (&SearchClient{URL: ts.URL, Encoding: EncodingMsgpack}).FindUsers(SearchRequest{Limit: 3, UseCursor: true})
+	if err != nil || resp.NextCursor == "" || len(resp.Users) != 3 {
+		t.Errorf("unexpected cursor response %+v, %v", resp, err)
+	}
+
+	// next_page of cursor mode is false on the last page
+	cursor := ""
+	for _, expected := range []struct {
+		users    int
+		nextPage bool
+	}{{20, true}, {15, false}} {
+		r, _ := http.NewRequest(http.MethodGet, ts.URL+"?limit=20&cursor="+url.QueryEscape(cursor), nil)
+		r.Header.Set("Accept", mediaTypeV2JSON)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := &searchEnvelope{}
+		err = json.NewDecoder(resp.Body).Decode(env)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(env.Users) != expected.users || env.Page.NextPage != expected.nextPage || (env.Page.NextCursor != "") != expected.nextPage {
+			t.Errorf("expected %d users and next_page %v, got %d users, %+v", expected.users, expected.nextPage, len(env.Users), env.Page)
+		}
+		cursor = env.Page.NextCursor
+	}
+
+	sizes := make(map[string]int)
+	for _, accept := range []string{mediaTypeV2JSON, mediaTypeV2Msgpack, "text/html"} {
+		r, _ := http.NewRequest(http.MethodGet, ts.URL+"?limit=25", nil)
+		r.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		sizes[resp.Header.Get("Content-Type")] = len(body)
+		if accept == "text/html" && resp.StatusCode != http.StatusNotAcceptable {
+			t.Errorf("expected 406, got %d", resp.StatusCode)
+		}
+	}
+	if sizes[mediaTypeV2Msgpack] == 0 || sizes[mediaTypeV2Msgpack] >= sizes[mediaTypeV2JSON] {
+		t.Errorf("expected msgpack to be smaller than json: %v", sizes)
+	}
+}
+
+func TestRunLoad(t *testing.T) {
+	search := NewSearchServer(datasetPath, "")
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every fourth request fails
+		if atomic.AddInt32(&count, 1)%4 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		search.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	mix := []WeightedRequest{
+		{3, SearchRequest{Limit: 5, Query: "gender:male", OrderField: orderFieldAge}},
+		{1, SearchRequest{Limit: 5, OrderField: "About"}},
+	}
+	report, err := RunLoad(context.Background(), &SearchClient{URL: ts.URL}, LoadOptions{
+		Concurrency: 4,
+		Requests:    100,
+		Mix:         mix,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byType := report.ErrorsByType
+	if report.Requests != 100 || byType["server_error"] != 25 || byType["bad_order_field"] == 0 ||
+		report.Errors != byType["server_error"]+byType["bad_order_field"] {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if report.P50 <= 0 || report.P50 > report.P99 || report.P99 > report.Max || report.Throughput <= 0 {
+		t.Errorf("unexpected latencies %+v", report)
+	}
+	out := &strings.Builder{}
+	report.Write(out)
+	if !strings.Contains(out.String(), "server_error") || !regexp.MustCompile(`requests +100`).MatchString(out.String()) {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	// load by duration
+	report, err = RunLoad(context.Background(), &SearchClient{URL: ts.URL}, LoadOptions{
+		Concurrency: 2,
+		Duration:    50 * time.Millisecond,
+		Mix:         mix,
+	})
+	if err != nil || report.Requests == 0 || report.ErrorsByType["canceled"] != 0 {
+		t.Errorf("unexpected report by duration %+v, %v", report, err)
+	}
+
+	if _, err := RunLoad(context.Background(), &SearchClient{URL: ts.URL}, LoadOptions{Requests: 1}); err != errEmptyMix {
+		t.Errorf("expected errEmptyMix, got %v", err)
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	wr, err := ParseMix("3|age:>30 OR name:rose|Age|-1|20")
+	expected := WeightedRequest{3, SearchRequest{Limit: 20, Query: "age:>30 OR name:rose", OrderField: "Age", OrderBy: -1}}
+	if err != nil || !reflect.DeepEqual(wr, expected) {
+		t.Errorf("expected %+v, got %+v, %v", expected, wr, err)
+	}
+	wr, err = ParseMix("2")
+	if err != nil || !reflect.DeepEqual(wr, WeightedRequest{2, SearchRequest{Limit: 10}}) {
+		t.Errorf("unexpected %+v, %v", wr, err)
+	}
+	for _, s := range []string{"", "0|x", "1|x|Age|up", "1|x|Age|1|ten", "1|a|b|1|2|3"} {
+		if _, err := ParseMix(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if percentile(sorted, 50) != 5 || percentile(sorted, 99) != 10 || percentile(nil, 50) != 0 {
+		t.Error("unexpected percentiles")
+	}
+}
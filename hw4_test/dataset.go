@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dataset is a source of users of SearchServer.
+// Returned rows are shared between requests, callers must not modify them.
+type Dataset interface {
+	Rows() (Rows, error)
+}
+
+// Loader parses users export
+type Loader func(r io.Reader) (Rows, error)
+
+// LoadXML parses dataset.xml format: <root><row>...</row></root>
+func LoadXML(r io.Reader) (Rows, error) {
+	type Root struct {
+		XMLName xml.Name `xml:"root"`
+		Rows    []Row    `xml:"row"`
+	}
+	a := Root{}
+	if err := xml.NewDecoder(r).Decode(&a); err != nil {
+		return nil, err
+	}
+	return a.Rows, nil
+}
+
+// LoadJSON parses array of rows with the same field names as in xml
+func LoadJSON(r io.Reader) (Rows, error) {
+	rows := make(Rows, 0)
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// LoadCSV parses csv with header, columns are named as xml fields, unknown columns are ignored
+func LoadCSV(r io.Reader) (Rows, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return nil, fmt.Errorf("csv header: no id column")
+	}
+
+	rows := make(Rows, 0)
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+
+		row := Row{
+			FirstName: field("first_name"),
+			LastName:  field("last_name"),
+			About:     field("about"),
+			Gender:    field("gender"),
+		}
+		if row.ID, err = strconv.Atoi(field("id")); err != nil {
+			return nil, fmt.Errorf("csv line %d: bad id: %w", line, err)
+		}
+		if s := field("age"); s != "" {
+			if row.Age, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("csv line %d: bad age: %w", line, err)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// loaderFor chooses loader by extension of file, xml is default
+func loaderFor(path string) Loader {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return LoadJSON
+	case ".csv":
+		return LoadCSV
+	}
+	return LoadXML
+}
+
+func loadFile(path string, loader Loader) (Rows, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := loader(f)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	return rows, nil
+}
+
+func loadData(filename string) (Rows, error) {
+	return loadFile(filename, loaderFor(filename))
+}
+
+// FileDataset is a dataset file which is loaded once and reloaded when it is changed
+type FileDataset struct {
+	Path   string
+	Loader Loader
+
+	mu      sync.Mutex
+	rows    Rows
+	modTime time.Time
+	size    int64
+	lastErr error
+	// version of file which failed to load, it isn't loaded again until it is changed
+	failedModTime time.Time
+	failedSize    int64
+}
+
+// NewFileDataset creates dataset over file, loader is chosen by extension
+func NewFileDataset(path string) *FileDataset {
+	return &FileDataset{
+		Path:   path,
+		Loader: loaderFor(path),
+	}
+}
+
+// Rows returns rows of file, file is reloaded if its modification time or size are changed.
+// If reload fails, the last loaded rows are served and the error is kept in LastError,
+// the failed version of file isn't loaded again. Error is returned only if file was never loaded.
+func (d *FileDataset) Rows() (Rows, error) {
+	info, err := os.Stat(d.Path)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil {
+		return d.fallback(err, time.Time{}, -1)
+	}
+	modTime, size := info.ModTime(), info.Size()
+	if d.rows != nil && modTime.Equal(d.modTime) && size == d.size {
+		return d.rows, nil
+	}
+	if d.lastErr != nil && modTime.Equal(d.failedModTime) && size == d.failedSize {
+		return d.fallback(d.lastErr, modTime, size)
+	}
+	loader := d.Loader
+	if loader == nil {
+		loader = loaderFor(d.Path)
+	}
+	rows, err := loadFile(d.Path, loader)
+	if err != nil {
+		return d.fallback(err, modTime, size)
+	}
+	if rows == nil {
+		rows = Rows{}
+	}
+	d.rows, d.modTime, d.size, d.lastErr = rows, modTime, size, nil
+	return rows, nil
+}
+
+// LastError returns error of the last failed reload, nil if the last reload succeeded
+func (d *FileDataset) LastError() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastErr
+}
+
+// fallback keeps error of failed version of file and returns the last loaded rows,
+// error is logged once per version. It must be called under mu.
+func (d *FileDataset) fallback(err error, modTime time.Time, size int64) (Rows, error) {
+	known := d.lastErr != nil && modTime.Equal(d.failedModTime) && size == d.failedSize
+	d.lastErr, d.failedModTime, d.failedSize = err, modTime, size
+	if d.rows == nil {
+		return nil, err
+	}
+	if !known {
+		log.Printf("dataset %s: reload failed, serving last loaded rows: %v", d.Path, err)
+	}
+	return d.rows, nil
+}
+
+// MemoryDataset is an in-memory fixture of users
+type MemoryDataset Rows
+
+// Rows returns rows of fixture
+func (d MemoryDataset) Rows() (Rows, error) {
+	return Rows(d), nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Versions of search response, they are negotiated by Accept header:
+//
+//	application/json                   - v1, bare array of users
+//	application/vnd.search.v2+json    - v2, searchEnvelope in json
+//	application/vnd.search.v2+msgpack - v2, searchEnvelope in MessagePack
+//
+// Request without Accept or with */* gets v1. Errors are always SearchErrorResponse in json.
+const (
+	mediaTypeV1        = "application/json"
+	mediaTypeV2JSON    = "application/vnd.search.v2+json"
+	mediaTypeV2Msgpack = "application/vnd.search.v2+msgpack"
+
+	envelopeVersion = 2
+
+	errorNotAcceptable = `Accept invalid`
+)
+
+// Encoding is a format of responses which SearchClient asks for
+type Encoding int
+
+const (
+	// EncodingJSON asks for v2 envelope in json, v1 servers respond bare array
+	EncodingJSON Encoding = iota
+	// EncodingMsgpack asks for v2 envelope in MessagePack, it is compact for large pages
+	EncodingMsgpack
+	// EncodingV1 asks for bare array of users
+	EncodingV1
+)
+
+// accept returns Accept header for encoding
+func (e Encoding) accept() string {
+	switch e {
+	case EncodingMsgpack:
+		return mediaTypeV2Msgpack + ", " + mediaTypeV2JSON + ";q=0.9, " + mediaTypeV1 + ";q=0.5"
+	case EncodingV1:
+		return mediaTypeV1
+	}
+	return mediaTypeV2JSON + ", " + mediaTypeV1 + ";q=0.5"
+}
+
+// pageInfo describes position of page in search results
+type pageInfo struct {
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+	NextPage   bool   `json:"next_page"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// searchEnvelope is v2 response of search system
+type searchEnvelope struct {
+	Version int            `json:"version"`
+	Users   []searchResult `json:"users"`
+	// count of users matched by query
+	Total int      `json:"total"`
+	Page  pageInfo `json:"page"`
+}
+
+// negotiate chooses media type of response by Accept header, false means no type is acceptable
+func negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return mediaTypeV1, true
+	}
+
+	type candidate struct {
+		mediaType string
+		q         float64
+		// position in header, the first one wins among equal q
+		pos int
+	}
+	candidates := make([]candidate, 0)
+	for pos, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch mediaType {
+		case mediaTypeV1, mediaTypeV2JSON, mediaTypeV2Msgpack:
+		case "*/*", "application/*":
+			mediaType = mediaTypeV1
+		default:
+			continue
+		}
+		candidates = append(candidates, candidate{mediaType, q, pos})
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].mediaType, true
+}
+
+// encodeResults encodes users in media type
+func encodeResults(mediaType string, env *searchEnvelope) ([]byte, error) {
+	switch mediaType {
+	case mediaTypeV2JSON:
+		return json.Marshal(env)
+	case mediaTypeV2Msgpack:
+		buf := &bytes.Buffer{}
+		enc := msgpack.NewEncoder(buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(env); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return json.Marshal(env.Users)
+}
+
+// decodeResults decodes response of search system by its Content-Type, unknown types are decoded as v1
+func decodeResults(contentType string, body []byte) (*searchEnvelope, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	env := &searchEnvelope{Version: 1}
+	switch mediaType {
+	case mediaTypeV2JSON:
+		if err := json.Unmarshal(body, env); err != nil {
+			return nil, err
+		}
+	case mediaTypeV2Msgpack:
+		dec := msgpack.NewDecoder(bytes.NewReader(body))
+		dec.SetCustomStructTag("json")
+		if err := dec.Decode(env); err != nil {
+			return nil, err
+		}
+	default:
+		if err := json.Unmarshal(body, &env.Users); err != nil {
+			return nil, err
+		}
+		return env, nil
+	}
+	if env.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported version %d", env.Version)
+	}
+	return env, nil
+}
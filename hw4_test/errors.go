@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnauthorized is returned when search system rejects AccessToken
+	ErrUnauthorized = errors.New("Bad AccessToken")
+	// ErrBadOrderField is matched by BadOrderFieldError with errors.Is
+	ErrBadOrderField = errors.New(ErrorBadOrderField)
+	// ErrBadCursor is returned when search system can't use cursor for request
+	ErrBadCursor = errors.New(ErrorBadCursor)
+	// ErrBadQuery is matched by QueryError and by errors of malformed query from search system
+	ErrBadQuery = errors.New(ErrorBadQuery)
+	// ErrTokenRequest is returned when authenticator can't get token
+	ErrTokenRequest = errors.New("token request failed")
+	// ErrCircuitOpen is returned without request while CircuitBreaker is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+// BadOrderFieldError is returned when search system doesn't support order field
+type BadOrderFieldError struct {
+	Field string
+}
+
+func (e *BadOrderFieldError) Error() string {
+	return fmt.Sprintf("OrderFeld %s invalid", e.Field)
+}
+
+// Is makes errors.Is(err, ErrBadOrderField) true
+func (e *BadOrderFieldError) Is(target error) bool {
+	return target == ErrBadOrderField
+}
+
+// TimeoutError is returned when search system doesn't respond in time
+type TimeoutError struct {
+	// encoded parameters of request
+	Params string
+	Err    error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout for %s", e.Params)
+}
+
+// Unwrap returns error of http client
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout makes TimeoutError compatible with net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// ServerError is returned when search system responds with 5xx status
+type ServerError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *ServerError) Error() string {
+	return "SearchServer fatal error"
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	errNoBackends        = errors.New("no search backends")
+	errFederatedCursor   = errors.New("cursor mode is not supported by federated search")
+	errAllBackendsFailed = errors.New("all search backends failed")
+)
+
+// BackendError is an error of one backend of federated search
+type BackendError struct {
+	URL string
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("backend %s: %s", e.URL, e.Err)
+}
+
+// Unwrap returns error of backend
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// FederatedResponse is a merged page of users with errors of failed backends
+type FederatedResponse struct {
+	SearchResponse
+	Errors []*BackendError
+}
+
+// FederatedClient searches users in several backends and merges results as one stream
+type FederatedClient struct {
+	Clients []*SearchClient
+}
+
+// NewFederatedClient creates client over backends
+func NewFederatedClient(clients ...*SearchClient) *FederatedClient {
+	return &FederatedClient{Clients: clients}
+}
+
+// userKey returns function which makes order key of user like rowKey of SearchServer
+func userKey(field string, query QueryNode) func(u User) orderKey {
+	switch field {
+	case orderFieldRelevance:
+		return relevanceKey(query)
+	case orderFieldID:
+		return func(u User) orderKey { return orderKey{ID: u.Id} }
+	case orderFieldAge:
+		return func(u User) orderKey { return orderKey{Int: u.Age, ID: u.Id} }
+	case orderFieldName, "":
+		return func(u User) orderKey { return orderKey{Str: u.Name, ID: u.Id} }
+	}
+	return nil
+}
+
+// FindUsers is FindUsersContext with background context
+func (fc *FederatedClient) FindUsers(req SearchRequest) (*FederatedResponse, error) {
+	return fc.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext queries all backends concurrently and returns page of merged users.
+// Users are ordered by req.OrderField and req.OrderBy and de-duplicated by Id,
+// as is order keeps order of backends, relevance is ordered from the most relevant users.
+// Offset and Limit are applied to merged stream.
+// Failed backends are reported in FederatedResponse.Errors, error is returned only if all of them failed.
+func (fc *FederatedClient) FindUsersContext(ctx context.Context, req SearchRequest) (*FederatedResponse, error) {
+	if len(fc.Clients) == 0 {
+		return nil, errNoBackends
+	}
+	if req.cursorMode() {
+		return nil, errFederatedCursor
+	}
+	if req.Limit < 0 {
+		return nil, fmt.Errorf("limit must be > 0")
+	}
+	if req.Limit > maxPageSize {
+		req.Limit = maxPageSize
+	}
+	if req.Offset < 0 {
+		return nil, fmt.Errorf("offset must be > 0")
+	}
+	query, err := ParseQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	key := userKey(req.OrderField, query)
+	if key == nil {
+		return nil, &BadOrderFieldError{Field: req.OrderField}
+	}
+	orderBy := req.OrderBy
+	if orderBy == OrderByAsIs && req.OrderField == orderFieldRelevance {
+		orderBy = OrderByAsc
+	}
+
+	// the first offset+limit merged users are among the first offset+limit users of every backend,
+	// one more is needed to know about the next page
+	need := req.Offset + req.Limit + 1
+	backendReq := req
+	backendReq.Offset, backendReq.Limit = 0, maxPageSize
+
+	results := make([][]User, len(fc.Clients))
+	errs := make([]error, len(fc.Clients))
+	wg := &sync.WaitGroup{}
+	for i, c := range fc.Clients {
+		wg.Add(1)
+		go func(i int, c *SearchClient) {
+			defer wg.Done()
+			results[i], errs[i] = c.FindAllUsers(ctx, backendReq, IteratorOptions{MaxResults: need})
+		}(i, c)
+	}
+	wg.Wait()
+
+	resp := &FederatedResponse{}
+	lists := make([][]User, 0, len(results))
+	for i, err := range errs {
+		if err != nil {
+			resp.Errors = append(resp.Errors, &BackendError{URL: fc.Clients[i].URL, Err: err})
+			continue
+		}
+		lists = append(lists, results[i])
+	}
+	if len(lists) == 0 {
+		msgs := make([]string, len(resp.Errors))
+		for i, err := range resp.Errors {
+			msgs[i] = err.Error()
+		}
+		return resp, fmt.Errorf("%w: %s", errAllBackendsFailed, strings.Join(msgs, "; "))
+	}
+
+	merged := mergeUsers(lists, key, orderBy)
+	if req.Offset >= len(merged) {
+		merged = nil
+	} else {
+		merged = merged[req.Offset:]
+	}
+	if len(merged) > req.Limit {
+		resp.NextPage = true
+		merged = merged[:req.Limit]
+	}
+	resp.Users = merged
+	if resp.Users == nil {
+		resp.Users = []User{}
+	}
+	return resp, nil
+}
+
+// mergeUsers merges ordered lists of backends and removes users with the same Id
+func mergeUsers(lists [][]User, key func(u User) orderKey, orderBy int) []User {
+	merged := make([]User, 0)
+	for _, list := range lists {
+		merged = append(merged, list...)
+	}
+	switch orderBy {
+	case OrderByAsc:
+		sort.SliceStable(merged, func(i, j int) bool { return key(merged[i]).compare(key(merged[j])) < 0 })
+	case OrderByDesc:
+		sort.SliceStable(merged, func(i, j int) bool { return key(merged[i]).compare(key(merged[j])) > 0 })
+	}
+
+	seen := make(map[int]bool, len(merged))
+	unique := merged[:0]
+	for _, u := range merged {
+		if seen[u.Id] {
+			continue
+		}
+		seen[u.Id] = true
+		unique = append(unique, u)
+	}
+	return unique
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+)
+
+// IteratorOptions configures UserIterator
+type IteratorOptions struct {
+	// fetch next page in background while current one is iterated
+	Prefetch bool
+	// max count of users to return, 0 means all users
+	MaxResults int
+	// fetch pages by cursor instead of offset
+	UseCursor bool
+}
+
+type pageResult struct {
+	resp *SearchResponse
+	err  error
+}
+
+// UserIterator returns users of all pages one by one, pages are fetched using Offset and NextPage
+type UserIterator struct {
+	client *SearchClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    SearchRequest
+	opts   IteratorOptions
+
+	users   []User
+	pos     int
+	current User
+	// count of users returned by Next
+	count int
+	// count of users fetched from search system
+	fetched int
+	hasMore bool
+	cursor  string
+	pending <-chan pageResult
+	err     error
+	closed  bool
+}
+
+// Users returns iterator over all users matching req starting from req.Offset or req.Cursor.
+// req.Limit is used as page size. Iterator must be closed if it isn't read till the end.
+func (srv *SearchClient) Users(ctx context.Context, req SearchRequest, opts IteratorOptions) *UserIterator {
+	if req.Limit <= 0 || req.Limit > maxPageSize {
+		req.Limit = maxPageSize
+	}
+	opts.UseCursor = opts.UseCursor || req.cursorMode()
+	ctx, cancel := context.WithCancel(ctx)
+	return &UserIterator{
+		client:  srv,
+		ctx:     ctx,
+		cancel:  cancel,
+		req:     req,
+		opts:    opts,
+		hasMore: true,
+		cursor:  req.Cursor,
+	}
+}
+
+// fetch requests next page in background, result is buffered so goroutine doesn't leak
+func (it *UserIterator) fetch() <-chan pageResult {
+	req := it.req
+	if it.opts.UseCursor {
+		req.UseCursor, req.Cursor = true, it.cursor
+	} else {
+		req.Offset += it.fetched
+	}
+	if it.opts.MaxResults > 0 && it.opts.MaxResults-it.fetched < req.Limit {
+		req.Limit = it.opts.MaxResults - it.fetched
+	}
+
+	ch := make(chan pageResult, 1)
+	go func() {
+		resp, err := it.client.FindUsersContext(it.ctx, req)
+		ch <- pageResult{resp, err}
+	}()
+	return ch
+}
+
+// needMore checks if there are users which must be fetched
+func (it *UserIterator) needMore() bool {
+	return it.hasMore && (it.opts.MaxResults <= 0 || it.fetched < it.opts.MaxResults)
+}
+
+// Next moves iterator to the next user, it returns false when users are over or error occurred
+func (it *UserIterator) Next() bool {
+	if it.closed {
+		return false
+	}
+	if it.opts.MaxResults > 0 && it.count >= it.opts.MaxResults {
+		it.Close()
+		return false
+	}
+
+	for it.pos >= len(it.users) {
+		if !it.needMore() {
+			it.Close()
+			return false
+		}
+
+		pending := it.pending
+		if pending == nil {
+			pending = it.fetch()
+		}
+		it.pending = nil
+
+		res := <-pending
+		if res.err != nil {
+			it.err = res.err
+			it.Close()
+			return false
+		}
+		it.users, it.pos = res.resp.Users, 0
+		it.fetched += len(res.resp.Users)
+		it.hasMore = res.resp.NextPage && len(res.resp.Users) > 0
+		it.cursor = res.resp.NextCursor
+
+		if it.opts.Prefetch && it.needMore() {
+			it.pending = it.fetch()
+		}
+	}
+
+	it.current = it.users[it.pos]
+	it.pos++
+	it.count++
+	return true
+}
+
+// User returns current user
+func (it *UserIterator) User() User {
+	return it.current
+}
+
+// Err returns error which stopped iteration
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// Close stops iteration and cancels prefetch of next page
+func (it *UserIterator) Close() {
+	it.closed = true
+	it.cancel()
+}
+
+// FindAllUsers collects users of all pages
+func (srv *SearchClient) FindAllUsers(ctx context.Context, req SearchRequest, opts IteratorOptions) ([]User, error) {
+	it := srv.Users(ctx, req, opts)
+	defer it.Close()
+
+	users := make([]User, 0)
+	for it.Next() {
+		users = append(users, it.User())
+	}
+	return users, it.Err()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// WeightedRequest is a request of load mix, requests are chosen proportionally to Weight
+type WeightedRequest struct {
+	Weight  int
+	Request SearchRequest
+}
+
+// LoadOptions configures RunLoad
+type LoadOptions struct {
+	// count of concurrent workers, 0 means 1
+	Concurrency int
+	// total count of requests, 0 means requests are sent until Duration passes
+	Requests int
+	// max duration of load, 0 means no limit
+	Duration time.Duration
+	// mix of requests, it must not be empty
+	Mix []WeightedRequest
+	// seed of random choice of requests
+	Seed int64
+}
+
+// LoadReport is a result of RunLoad
+type LoadReport struct {
+	Requests int
+	Errors   int
+	// count of errors by type, see errorType
+	ErrorsByType map[string]int
+	Elapsed      time.Duration
+	// requests per second
+	Throughput float64
+	// latency percentiles of all requests
+	Mean, P50, P90, P95, P99, Max time.Duration
+}
+
+var errEmptyMix = errors.New("load mix is empty")
+
+// errorType classifies error of FindUsers for report
+func errorType(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrBadOrderField):
+		return "bad_order_field"
+	case errors.Is(err, ErrBadQuery):
+		return "bad_query"
+	case errors.Is(err, ErrBadCursor):
+		return "bad_cursor"
+	case errors.As(err, new(*ServerError)):
+		return "server_error"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "other"
+}
+
+// percentile returns value of sorted latencies by nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// pick chooses request of mix by weight
+func pick(mix []WeightedRequest, total int, rnd *rand.Rand) SearchRequest {
+	n := rnd.Intn(total)
+	for _, wr := range mix {
+		if n < wr.Weight {
+			return wr.Request
+		}
+		n -= wr.Weight
+	}
+	return mix[len(mix)-1].Request
+}
+
+// RunLoad sends requests of mix by concurrent workers and measures latencies and errors.
+// Load stops when Requests are sent, Duration passes or ctx is done.
+func RunLoad(ctx context.Context, cli *SearchClient, opts LoadOptions) (*LoadReport, error) {
+	total := 0
+	for _, wr := range opts.Mix {
+		if wr.Weight < 0 {
+			return nil, fmt.Errorf("negative weight of %q", wr.Request.Query)
+		}
+		total += wr.Weight
+	}
+	if total == 0 {
+		return nil, errEmptyMix
+	}
+	if opts.Requests <= 0 && opts.Duration <= 0 {
+		return nil, fmt.Errorf("requests or duration must be set")
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	// tickets limit total count of requests, closed channel means no limit
+	tickets := make(chan struct{}, workers)
+	go func() {
+		defer close(tickets)
+		for i := 0; opts.Requests <= 0 || i < opts.Requests; i++ {
+			select {
+			case tickets <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	mu := &sync.Mutex{}
+	latencies := make([]time.Duration, 0, opts.Requests)
+	report := &LoadReport{ErrorsByType: make(map[string]int)}
+
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			for range tickets {
+				if ctx.Err() != nil {
+					return
+				}
+				req := pick(opts.Mix, total, rnd)
+				began := time.Now()
+				_, err := cli.FindUsersContext(ctx, req)
+				latency := time.Since(began)
+				// requests interrupted by the end of load are not counted
+				if err != nil && ctx.Err() != nil {
+					return
+				}
+
+				mu.Lock()
+				report.Requests++
+				latencies = append(latencies, latency)
+				if err != nil {
+					report.Errors++
+					report.ErrorsByType[errorType(err)]++
+				}
+				mu.Unlock()
+			}
+		}(rand.New(rand.NewSource(opts.Seed + int64(i))))
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	if report.Elapsed > 0 {
+		report.Throughput = float64(report.Requests) / report.Elapsed.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	if len(latencies) > 0 {
+		report.Mean = sum / time.Duration(len(latencies))
+		report.Max = latencies[len(latencies)-1]
+	}
+	report.P50 = percentile(latencies, 50)
+	report.P90 = percentile(latencies, 90)
+	report.P95 = percentile(latencies, 95)
+	report.P99 = percentile(latencies, 99)
+	return report, nil
+}
+
+// Write writes report as a table
+func (r *LoadReport) Write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "requests\t%d\n", r.Requests)
+	fmt.Fprintf(w, "elapsed\t%s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput\t%.1f req/s\n", r.Throughput)
+	fmt.Fprintf(w, "latency\tmean %s\tp50 %s\tp90 %s\tp95 %s\tp99 %s\tmax %s\n",
+		r.Mean, r.P50, r.P90, r.P95, r.P99, r.Max)
+	rate := 0.0
+	if r.Requests > 0 {
+		rate = float64(r.Errors) / float64(r.Requests) * 100
+	}
+	fmt.Fprintf(w, "errors\t%d\t%.2f%%\n", r.Errors, rate)
+
+	types := make([]string, 0, len(r.ErrorsByType))
+	for t := range r.ErrorsByType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "  %s\t%d\t%.2f%%\n", t, r.ErrorsByType[t], float64(r.ErrorsByType[t])/float64(r.Requests)*100)
+	}
+	return w.Flush()
+}
+
+// ParseMix parses request of load mix: weight|query|order_field|order_by|limit,
+// all fields except weight are optional
+func ParseMix(s string) (WeightedRequest, error) {
+	parts := strings.Split(s, "|")
+	if len(parts) > 5 {
+		return WeightedRequest{}, fmt.Errorf("bad mix %q: too many fields", s)
+	}
+	wr := WeightedRequest{Request: SearchRequest{Limit: 10}}
+
+	var err error
+	if wr.Weight, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil || wr.Weight <= 0 {
+		return wr, fmt.Errorf("bad mix %q: weight must be positive int", s)
+	}
+	if len(parts) > 1 {
+		wr.Request.Query = parts[1]
+	}
+	if len(parts) > 2 {
+		wr.Request.OrderField = strings.TrimSpace(parts[2])
+	}
+	if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
+		if wr.Request.OrderBy, err = strconv.Atoi(strings.TrimSpace(parts[3])); err != nil {
+			return wr, fmt.Errorf("bad mix %q: order_by must be int", s)
+		}
+	}
+	if len(parts) > 4 && strings.TrimSpace(parts[4]) != "" {
+		if wr.Request.Limit, err = strconv.Atoi(strings.TrimSpace(parts[4])); err != nil {
+			return wr, fmt.Errorf("bad mix %q: limit must be int", s)
+		}
+	}
+	return wr, nil
+}
+
+// defaultMix is used by load command when mix isn't set
+var defaultMix = []WeightedRequest{
+	{5, SearchRequest{Limit: 10}},
+	{3, SearchRequest{Limit: 10, Query: "gender:female", OrderField: orderFieldAge, OrderBy: OrderByAsc}},
+	{2, SearchRequest{Limit: 25, Query: "dolor", OrderField: orderFieldRelevance}},
+	{1, SearchRequest{Limit: 5, Offset: 10, Query: "age:>30 OR name:rose", OrderField: orderFieldName, OrderBy: OrderByDesc}},
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+// mixFlag collects repeated -mix flags
+type mixFlag []WeightedRequest
+
+func (m *mixFlag) String() string {
+	return fmt.Sprintf("%d requests", len(*m))
+}
+
+func (m *mixFlag) Set(value string) error {
+	wr, err := ParseMix(value)
+	if err != nil {
+		return err
+	}
+	*m = append(*m, wr)
+	return nil
+}
+
+var encodings = map[string]Encoding{
+	"json":    EncodingJSON,
+	"msgpack": EncodingMsgpack,
+	"v1":      EncodingV1,
+}
+
+// main is a load generator for search system:
+//
+//	go run . -c 20 -n 5000 -mix '3|gender:female|Age|-1' -mix '1|dolor|Relevance'
+//
+// Without -url it runs the reference SearchServer over -dataset in process.
+func main() {
+	os.Exit(run())
+}
+
+// run runs load and returns exit code, so deferred calls are done before exit
+func run() int {
+	var mix mixFlag
+	url := flag.String("url", "", "url of search system, empty means in-process reference server")
+	dataset := flag.String("dataset", "dataset.xml", "dataset of in-process server")
+	token := flag.String("token", "", "AccessToken")
+	concurrency := flag.Int("c", 10, "count of concurrent workers")
+	requests := flag.Int("n", 1000, "total count of requests, 0 means until -d passes")
+	duration := flag.Duration("d", 0, "max duration of load, 0 means no limit")
+	timeout := flag.Duration("timeout", defaultTimeout, "timeout of one request")
+	encoding := flag.String("encoding", "json", "encoding of responses: json, msgpack or v1")
+	seed := flag.Int64("seed", 1, "seed of random choice of requests")
+	flag.Var(&mix, "mix", "request of mix: weight|query|order_field|order_by|limit, can be repeated")
+	flag.Parse()
+
+	enc, ok := encodings[*encoding]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown encoding %s\n", *encoding)
+		return 2
+	}
+	if len(mix) == 0 {
+		mix = defaultMix
+	}
+
+	if *url == "" {
+		ts := httptest.NewServer(NewSearchServer(*dataset, *token))
+		defer ts.Close()
+		*url = ts.URL
+	}
+	cli := &SearchClient{
+		URL:         *url,
+		AccessToken: *token,
+		Encoding:    enc,
+		HTTPClient: &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+		},
+	}
+
+	fmt.Printf("load %s: %d workers, %d requests, duration %s\n", *url, *concurrency, *requests, *duration)
+	for _, wr := range mix {
+		fmt.Printf("  %d x %+v\n", wr.Weight, wr.Request)
+	}
+	report, err := RunLoad(context.Background(), cli, LoadOptions{
+		Concurrency: *concurrency,
+		Requests:    *requests,
+		Duration:    *duration,
+		Mix:         mix,
+		Seed:        *seed,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	report.Write(os.Stdout)
+	if report.Requests > 0 && report.Errors == report.Requests {
+		return 1
+	}
+	return 0
+}
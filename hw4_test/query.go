@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query language of SearchRequest.Query:
+//
+//	rose                     - name or about contains "rose"
+//	gender:female age:>30    - terms are joined by AND
+//	about:"dolor sit"        - quoted value with spaces
+//	name:rose OR NOT id:<=10 - AND, OR, NOT and parentheses
+//	-about:lorem             - minus is a short form of NOT
+//
+// Text values match case insensitive, gender is compared entirely, name and about by substring.
+// Numeric fields id and age support =, >, >=, <, <=.
+// Plain text with :, (, ), " or leading minus is a part of query syntax,
+// so it must be quoted to be searched as is: "10:30" and "-1". Malformed query is ErrBadQuery.
+
+const (
+	queryFieldName   = "name"
+	queryFieldAbout  = "about"
+	queryFieldGender = "gender"
+	queryFieldAge    = "age"
+	queryFieldID     = "id"
+)
+
+// QueryNode is a node of parsed query
+type QueryNode interface {
+	Match(u User) bool
+	String() string
+}
+
+// QueryError describes malformed query
+type QueryError struct {
+	// position of error in query, in bytes
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Is makes errors.Is(err, ErrBadQuery) true
+func (e *QueryError) Is(target error) bool {
+	return target == ErrBadQuery
+}
+
+type andNode struct {
+	left, right QueryNode
+}
+
+func (n *andNode) Match(u User) bool {
+	return n.left.Match(u) && n.right.Match(u)
+}
+
+func (n *andNode) String() string {
+	return "(" + n.left.String() + " AND " + n.right.String() + ")"
+}
+
+type orNode struct {
+	left, right QueryNode
+}
+
+func (n *orNode) Match(u User) bool {
+	return n.left.Match(u) || n.right.Match(u)
+}
+
+func (n *orNode) String() string {
+	return "(" + n.left.String() + " OR " + n.right.String() + ")"
+}
+
+type notNode struct {
+	expr QueryNode
+}
+
+func (n *notNode) Match(u User) bool {
+	return !n.expr.Match(u)
+}
+
+func (n *notNode) String() string {
+	return "NOT " + n.expr.String()
+}
+
+// termNode is field:value, empty field means name or about
+type termNode struct {
+	field string
+	op    string
+	value string
+	num   int
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), substr)
+}
+
+func compareNum(a int, op string, b int) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return a == b
+}
+
+func (n *termNode) Match(u User) bool {
+	switch n.field {
+	case queryFieldName:
+		return containsFold(u.Name, n.value)
+	case queryFieldAbout:
+		return containsFold(u.About, n.value)
+	case queryFieldGender:
+		return strings.EqualFold(u.Gender, n.value)
+	case queryFieldAge:
+		return compareNum(u.Age, n.op, n.num)
+	case queryFieldID:
+		return compareNum(u.Id, n.op, n.num)
+	}
+	return containsFold(u.Name, n.value) || containsFold(u.About, n.value)
+}
+
+func (n *termNode) String() string {
+	value := strconv.Quote(n.value)
+	if n.field == "" {
+		return value
+	}
+	return n.field + ":" + n.op + value
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenQuoted
+	tokenLParen
+	tokenRParen
+	tokenColon
+	tokenOp
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// lexQuery splits query to tokens
+func lexQuery(q string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ':':
+			tokens = append(tokens, token{tokenColon, ":", i})
+			i++
+		case c == '>' || c == '<' || c == '=':
+			op := string(c)
+			if c != '=' && i+1 < len(q) && q[i+1] == '=' {
+				op += "="
+			}
+			tokens = append(tokens, token{tokenOp, op, i})
+			i += len(op)
+		case c == '"':
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				return nil, &QueryError{i, "unterminated quote"}
+			}
+			tokens = append(tokens, token{tokenQuoted, q[i+1 : i+1+end], i})
+			i += end + 2
+		default:
+			start := i
+			for i < len(q) && !strings.ContainsRune(" \t\n():<>=\"", rune(q[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, q[start:i], start})
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(q)}), nil
+}
+
+type queryParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) isKeyword(value string) bool {
+	t := p.peek()
+	return t.kind == tokenWord && t.value == value
+}
+
+// parseOr: and {OR and}
+func (p *queryParser) parseOr() (QueryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+// parseAnd: not {[AND] not}
+func (p *queryParser) parseAnd() (QueryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind == tokenEOF || t.kind == tokenRParen || p.isKeyword("OR") {
+			return left, nil
+		}
+		if p.isKeyword("AND") {
+			p.next()
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+}
+
+// parseNot: NOT not | -not | primary
+func (p *queryParser) parseNot() (QueryNode, error) {
+	t := p.peek()
+	negated := p.isKeyword("NOT") || (t.kind == tokenWord && strings.HasPrefix(t.value, "-"))
+	if negated {
+		if t.value == "NOT" || t.value == "-" {
+			p.next()
+		} else {
+			// -term, the rest of word is parsed as term
+			p.tokens[p.pos].value = t.value[1:]
+			p.tokens[p.pos].pos++
+		}
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary: ( or ) | term
+func (p *queryParser) parsePrimary() (QueryNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, &QueryError{closing.pos, "expected )"}
+		}
+		return expr, nil
+	case tokenQuoted:
+		return &termNode{value: strings.ToLower(t.value)}, nil
+	case tokenWord:
+		if t.value == "AND" || t.value == "OR" {
+			return nil, &QueryError{t.pos, "unexpected " + t.value}
+		}
+		if p.peek().kind == tokenColon {
+			p.next()
+			return p.parseTerm(t)
+		}
+		return &termNode{value: strings.ToLower(t.value)}, nil
+	case tokenEOF:
+		return nil, &QueryError{t.pos, "unexpected end of query"}
+	}
+	return nil, &QueryError{t.pos, "unexpected " + t.value}
+}
+
+// parseTerm parses value of field term: [op] value
+func (p *queryParser) parseTerm(field token) (QueryNode, error) {
+	name := strings.ToLower(field.value)
+	node := &termNode{field: name}
+
+	if op := p.peek(); op.kind == tokenOp {
+		p.next()
+		if name != queryFieldAge && name != queryFieldID {
+			return nil, &QueryError{op.pos, "operator " + op.value + " is allowed only for age and id"}
+		}
+		node.op = op.value
+	}
+
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenQuoted {
+		return nil, &QueryError{value.pos, "expected value of " + name}
+	}
+	node.value = strings.ToLower(value.value)
+
+	switch name {
+	case queryFieldName, queryFieldAbout, queryFieldGender:
+	case queryFieldAge, queryFieldID:
+		num, err := strconv.Atoi(value.value)
+		if err != nil {
+			return nil, &QueryError{value.pos, name + " must be int"}
+		}
+		node.num = num
+	default:
+		return nil, &QueryError{field.pos, "unknown field " + field.value}
+	}
+	return node, nil
+}
+
+// ParseQuery parses query language, empty query gives nil node which matches all users
+func ParseQuery(q string) (QueryNode, error) {
+	if strings.TrimFunc(q, unicode.IsSpace) == "" {
+		return nil, nil
+	}
+	tokens, err := lexQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &QueryError{t.pos, "unexpected " + t.value}
+	}
+	return node, nil
+}
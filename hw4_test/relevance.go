@@ -0,0 +1,128 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// OrderField which orders users by relevance to query, the most relevant are the first
+	orderFieldRelevance = "Relevance"
+
+	// term in name is more important than in about
+	nameWeight = 3
+	// count of bytes of about around the first match in snippet
+	snippetContext = 40
+
+	highlightStart  = "<em>"
+	highlightEnd    = "</em>"
+	snippetEllipsis = "..."
+)
+
+// queryTerms collects terms of query which are not negated
+func queryTerms(node QueryNode) []*termNode {
+	switch n := node.(type) {
+	case *termNode:
+		return []*termNode{n}
+	case *andNode:
+		return append(queryTerms(n.left), queryTerms(n.right)...)
+	case *orNode:
+		return append(queryTerms(n.left), queryTerms(n.right)...)
+	}
+	return nil
+}
+
+// relevance is term frequency of text terms of query in Name and About, Name has bigger weight
+func relevance(terms []*termNode, u User) int {
+	name, about := strings.ToLower(u.Name), strings.ToLower(u.About)
+	score := 0
+	for _, t := range terms {
+		if t.value == "" {
+			continue
+		}
+		switch t.field {
+		case "":
+			score += nameWeight*strings.Count(name, t.value) + strings.Count(about, t.value)
+		case queryFieldName:
+			score += nameWeight * strings.Count(name, t.value)
+		case queryFieldAbout:
+			score += strings.Count(about, t.value)
+		}
+	}
+	return score
+}
+
+// relevanceKey makes order key where the most relevant users are the least ones
+func relevanceKey(query QueryNode) func(u User) orderKey {
+	terms := queryTerms(query)
+	return func(u User) orderKey {
+		return orderKey{Int: -relevance(terms, u), ID: u.Id}
+	}
+}
+
+// highlighter marks matches of query terms in About
+type highlighter struct {
+	re *regexp.Regexp
+}
+
+// newHighlighter creates highlighter for terms of query which are matched in About, nil if there are no such terms
+func newHighlighter(query QueryNode) *highlighter {
+	parts := make([]string, 0)
+	for _, t := range queryTerms(query) {
+		if t.value != "" && (t.field == "" || t.field == queryFieldAbout) {
+			parts = append(parts, regexp.QuoteMeta(t.value))
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return &highlighter{re: regexp.MustCompile("(?i)" + strings.Join(parts, "|"))}
+}
+
+// snippet returns fragment of text around the first match with all matches in it marked, empty if nothing is matched
+func (h *highlighter) snippet(text string) string {
+	matches := h.re.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	start := matches[0][0] - snippetContext
+	if start < 0 {
+		start = 0
+	}
+	end := matches[0][1] + snippetContext
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	b := &strings.Builder{}
+	if start > 0 {
+		b.WriteString(snippetEllipsis)
+	}
+	pos := start
+	for _, m := range matches {
+		if m[0] < pos {
+			continue
+		}
+		if m[1] > end {
+			break
+		}
+		b.WriteString(text[pos:m[0]])
+		b.WriteString(highlightStart)
+		b.WriteString(text[m[0]:m[1]])
+		b.WriteString(highlightEnd)
+		pos = m[1]
+	}
+	b.WriteString(text[pos:end])
+	if end < len(text) {
+		b.WriteString(snippetEllipsis)
+	}
+	return b.String()
+}
@@ -0,0 +1,25 @@
+// Package searchapi describes protocol of search system.
+// It is shared by SearchClient, SearchServer and searchfake, so fakes can't drift from the client.
+package searchapi
+
+// User is a user in responses of search system
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+// ErrorResponse is a body of 400 responses
+type ErrorResponse struct {
+	Error string
+}
+
+// errors of 400 responses
+const (
+	ErrorBadOrderField = `OrderField invalid`
+	ErrorBadCursor     = `Cursor invalid`
+	// errors of malformed query start with it
+	ErrorBadQuery = `Query invalid`
+)
@@ -0,0 +1,250 @@
+// Package searchfake is a fake of external search system for tests of code which uses SearchClient.
+// Responses are programmed by scenario: scripted responses are returned once in order,
+// rules are matched on every request, default response is returned otherwise.
+// All requests are recorded.
+package searchfake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"hw4_test/searchapi"
+)
+
+// User is a user in the format of search system
+type User = searchapi.User
+
+// Response is a programmed response of fake server
+type Response struct {
+	Status int
+	Header http.Header
+	// raw body, it is written as is if it isn't nil
+	Body []byte
+	// users are written as json array if Body is nil and Error is empty
+	Users []User
+	// error is written as SearchErrorResponse
+	Error string
+	// delay before response, it is interrupted if client closes request
+	Latency time.Duration
+}
+
+// Users responds 200 with users
+func Users(users ...User) Response {
+	if users == nil {
+		users = []User{}
+	}
+	return Response{Status: http.StatusOK, Users: users}
+}
+
+// Status responds with code and empty body
+func Status(code int) Response {
+	return Response{Status: code, Body: []byte{}}
+}
+
+// Error responds with code and SearchErrorResponse
+func Error(code int, msg string) Response {
+	return Response{Status: code, Error: msg}
+}
+
+// Malformed responds with code and raw body, e.g. broken json
+func Malformed(code int, body string) Response {
+	return Response{Status: code, Body: []byte(body)}
+}
+
+// Unauthorized responds as search system which rejected AccessToken
+func Unauthorized() Response {
+	return Status(http.StatusUnauthorized)
+}
+
+// BadOrderField responds as search system which doesn't support order field
+func BadOrderField() Response {
+	return Error(http.StatusBadRequest, searchapi.ErrorBadOrderField)
+}
+
+// Timeout responds after latency which must be greater than timeout of client
+func Timeout(latency time.Duration) Response {
+	return Users().WithLatency(latency)
+}
+
+// WithLatency returns copy of response with delay
+func (r Response) WithLatency(d time.Duration) Response {
+	r.Latency = d
+	return r
+}
+
+// WithHeader returns copy of response with header
+func (r Response) WithHeader(key, value string) Response {
+	h := http.Header{}
+	for k, v := range r.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set(key, value)
+	r.Header = h
+	return r
+}
+
+func (r Response) write(w http.ResponseWriter, req *http.Request) {
+	if r.Latency > 0 {
+		timer := time.NewTimer(r.Latency)
+		defer timer.Stop()
+		select {
+		case <-req.Context().Done():
+			return
+		case <-timer.C:
+		}
+	}
+
+	body := r.Body
+	switch {
+	case body != nil:
+	case r.Error != "":
+		body, _ = json.Marshal(searchapi.ErrorResponse{Error: r.Error})
+	default:
+		users := r.Users
+		if users == nil {
+			users = []User{}
+		}
+		body, _ = json.Marshal(users)
+	}
+
+	for k, v := range r.Header {
+		w.Header()[k] = v
+	}
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Matcher checks if rule must be applied to request
+type Matcher func(r *http.Request) bool
+
+// Any matches all requests
+func Any() Matcher {
+	return func(r *http.Request) bool { return true }
+}
+
+// Param matches requests with query parameter, e.g. Param("query", "timeout")
+func Param(name, value string) Matcher {
+	return func(r *http.Request) bool { return r.URL.Query().Get(name) == value }
+}
+
+// Token matches requests with token in AccessToken header or in Authorization header
+// as Bearer token, so it fakes both default auth of SearchClient and BearerToken or ClientCredentials.
+// Signatures of HMACSigner aren't verified, use Header to match its key.
+func Token(token string) Matcher {
+	return func(r *http.Request) bool {
+		return r.Header.Get("AccessToken") == token || r.Header.Get("Authorization") == "Bearer "+token
+	}
+}
+
+// Header matches requests with header value
+func Header(name, value string) Matcher {
+	return func(r *http.Request) bool { return r.Header.Get(name) == value }
+}
+
+type rule struct {
+	match Matcher
+	resp  Response
+}
+
+// Request is a recorded request
+type Request struct {
+	Method string
+	Query  url.Values
+	Header http.Header
+	Time   time.Time
+}
+
+// Server is a fake search system
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	script   []Response
+	rules    []rule
+	def      Response
+	requests []Request
+}
+
+// NewServer starts fake server which responds with empty users list until it is programmed
+func NewServer() *Server {
+	s := &Server{def: Users()}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Enqueue adds responses which are returned once in order, before rules
+func (s *Server) Enqueue(resps ...Response) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, resps...)
+	return s
+}
+
+// On adds rule which responds to matched requests, rules are checked in order of adding
+func (s *Server) On(match Matcher, resp Response) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, rule{match, resp})
+	return s
+}
+
+// Default sets response for requests not matched by script and rules
+func (s *Server) Default(resp Response) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.def = resp
+	return s
+}
+
+// Requests returns recorded requests
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset removes script, rules and recorded requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script, s.rules, s.requests = nil, nil, nil
+	s.def = Users()
+}
+
+// next records request and chooses response for it
+func (s *Server) next(r *http.Request) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Time:   time.Now(),
+	})
+
+	if len(s.script) > 0 {
+		resp := s.script[0]
+		s.script = s.script[1:]
+		return resp
+	}
+	for _, rule := range s.rules {
+		if rule.match(r) {
+			return rule.resp
+		}
+	}
+	return s.def
+}
+
+// ServeHTTP responds by scenario
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.next(r).write(w, r)
+}
@@ -0,0 +1,88 @@
+package searchfake
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url, token string) (int, string) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("AccessToken", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestScenario(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Enqueue(Status(http.StatusServiceUnavailable), Malformed(http.StatusOK, `{"status": 400`)).
+		On(Token("bad"), Unauthorized()).
+		On(Param("order_field", "About"), BadOrderField()).
+		Default(Users(User{Id: 1, Name: "Boyd Wolf"}))
+
+	for i, expected := range []struct {
+		url, token string
+		code       int
+		body       string
+	}{
+		{"/", "", http.StatusServiceUnavailable, ""},
+		{"/", "", http.StatusOK, `{"status": 400`},
+		{"/", "bad", http.StatusUnauthorized, ""},
+		{"/?order_field=About", "", http.StatusBadRequest, `{"Error":"OrderField invalid"}`},
+		{"/?query=x", "", http.StatusOK, `[{"Id":1,"Name":"Boyd Wolf","Age":0,"About":"","Gender":""}]`},
+	} {
+		code, body := get(t, s.URL+expected.url, expected.token)
+		if code != expected.code || body != expected.body {
+			t.Errorf("request %d: expected %d %s, got %d %s", i, expected.code, expected.body, code, body)
+		}
+	}
+
+	requests := s.Requests()
+	if len(requests) != 5 || requests[2].Header.Get("AccessToken") != "bad" || requests[4].Query.Get("query") != "x" {
+		t.Errorf("unexpected recorded requests %+v", requests)
+	}
+
+	s.Reset()
+	if code, body := get(t, s.URL, ""); code != http.StatusOK || body != "[]" || len(s.Requests()) != 1 {
+		t.Errorf("expected empty scenario after reset, got %d %s", code, body)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Default(Timeout(time.Second).WithHeader("Retry-After", "1"))
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	if _, err := client.Get(s.URL); err == nil {
+		t.Error("expected timeout")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("request is not interrupted")
+	}
+
+	s.Default(Users().WithLatency(20 * time.Millisecond).WithHeader("Retry-After", "1"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	users := []User{}
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("unexpected response %v, %v", resp.Header, err)
+	}
+}
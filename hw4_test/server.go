@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	orderFieldID   = "Id"
+	orderFieldAge  = "Age"
+	orderFieldName = "Name"
+
+	errorBadOrderBy = `OrderBy invalid`
+	errorBadLimit   = `Limit invalid`
+	errorBadOffset  = `Offset invalid`
+)
+
+type Row struct {
+	XMLName   xml.Name `xml:"row" json:"-"`
+	ID        int      `xml:"id" json:"id"`
+	FirstName string   `xml:"first_name" json:"first_name"`
+	LastName  string   `xml:"last_name" json:"last_name"`
+	Age       int      `xml:"age" json:"age"`
+	About     string   `xml:"about" json:"about"`
+	Gender    string   `xml:"gender" json:"gender"`
+}
+
+func (r Row) Name() string {
+	return r.FirstName + " " + r.LastName
+}
+
+func (r Row) User() User {
+	return User{
+		Id:     r.ID,
+		Name:   r.Name(),
+		Age:    r.Age,
+		Gender: r.Gender,
+		About:  r.About,
+	}
+}
+
+type Rows []Row
+
+// orderKey is a position of row in total order by (OrderField, Id)
+type orderKey struct {
+	Int int    `json:"n,omitempty"`
+	Str string `json:"s,omitempty"`
+	ID  int    `json:"i"`
+}
+
+func (k orderKey) compare(other orderKey) int {
+	switch {
+	case k.Int != other.Int:
+		return compareInts(k.Int, other.Int)
+	case k.Str != other.Str:
+		return strings.Compare(k.Str, other.Str)
+	}
+	return compareInts(k.ID, other.ID)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// rowKey returns function which makes order key of row for order field, nil if field is unknown
+func rowKey(field string) func(r Row) orderKey {
+	switch field {
+	case orderFieldID:
+		return func(r Row) orderKey { return orderKey{ID: r.ID} }
+	case orderFieldAge:
+		return func(r Row) orderKey { return orderKey{Int: r.Age, ID: r.ID} }
+	case orderFieldName, "":
+		return func(r Row) orderKey { return orderKey{Str: r.Name(), ID: r.ID} }
+	}
+	return nil
+}
+
+// relevanceRowKey makes relevance order key of row, scores are computed once per row
+func relevanceRowKey(query QueryNode) func(r Row) orderKey {
+	key := relevanceKey(query)
+	keys := make(map[int]orderKey)
+	return func(r Row) orderKey {
+		k, ok := keys[r.ID]
+		if !ok {
+			k = key(r.User())
+			keys[r.ID] = k
+		}
+		return k
+	}
+}
+
+// searchCursor is encoded to opaque cursor, it is valid only for request with the same parameters
+type searchCursor struct {
+	OrderField string   `json:"f,omitempty"`
+	OrderBy    int      `json:"b,omitempty"`
+	Query      string   `json:"q,omitempty"`
+	Last       orderKey `json:"l"`
+}
+
+func (c searchCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes cursor and checks that it belongs to the same search
+func decodeCursor(s string, params SearchRequest) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	c := &searchCursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, ErrBadCursor
+	}
+	if c.OrderField != params.OrderField || c.OrderBy != params.OrderBy || c.Query != params.Query {
+		return nil, ErrBadCursor
+	}
+	return c, nil
+}
+
+// SearchServer is a reference implementation of external search system over dataset.xml
+type SearchServer struct {
+	// path to dataset, format is chosen by extension, it is used when Dataset is nil
+	DatasetPath string
+	// source of users, it is shared between requests and must not be modified
+	Dataset Dataset
+	// token which clients must send in AccessToken header, empty means no authorization
+	AccessToken string
+
+	once sync.Once
+}
+
+// NewSearchServer creates search server over dataset file which is reloaded on change
+func NewSearchServer(datasetPath, accessToken string) *SearchServer {
+	return &SearchServer{
+		DatasetPath: datasetPath,
+		Dataset:     NewFileDataset(datasetPath),
+		AccessToken: accessToken,
+	}
+}
+
+// dataset returns Dataset, server created without it uses file DatasetPath
+func (srv *SearchServer) dataset() Dataset {
+	srv.once.Do(func() {
+		if srv.Dataset == nil {
+			srv.Dataset = NewFileDataset(srv.DatasetPath)
+		}
+	})
+	return srv.Dataset
+}
+
+// searchPage is a page of users with position in search results
+type searchPage struct {
+	Users []User
+	// cursor of the next page in cursor mode, empty if there are no more users
+	NextCursor string
+	// count of users matched by query
+	Total int
+}
+
+// Search filters, orders and paginates users of dataset.
+// In cursor mode it returns cursor of the next page, empty if there are no more users.
+func (srv *SearchServer) Search(params SearchRequest) ([]User, string, error) {
+	page, err := srv.search(params)
+	if err != nil {
+		return nil, "", err
+	}
+	return page.Users, page.NextCursor, nil
+}
+
+func (srv *SearchServer) search(params SearchRequest) (*searchPage, error) {
+	key := rowKey(params.OrderField)
+	if key == nil && params.OrderField != orderFieldRelevance {
+		return nil, ErrBadOrderField
+	}
+
+	var cursor *searchCursor
+	if params.Cursor != "" {
+		var err error
+		if cursor, err = decodeCursor(params.Cursor, params); err != nil {
+			return nil, err
+		}
+	}
+
+	query, err := ParseQuery(params.Query)
+	if err != nil {
+		return nil, err
+	}
+	if params.OrderField == orderFieldRelevance {
+		key = relevanceRowKey(query)
+	}
+
+	rows, err := srv.dataset().Rows()
+	if err != nil {
+		return nil, err
+	}
+
+	// search, rows of dataset are shared so they are copied before sorting
+	var filtered Rows
+	if query == nil {
+		filtered = append(Rows(nil), rows...)
+	} else {
+		for i, row := range rows {
+			if query.Match(row.User()) {
+				filtered = append(filtered, rows[i])
+			}
+		}
+	}
+
+	// order, as is order is ordered by Id in cursor mode to be stable,
+	// relevance is always ordered from the most relevant users
+	orderBy := params.OrderBy
+	if orderBy == OrderByAsIs && params.OrderField == orderFieldRelevance {
+		orderBy = OrderByAsc
+	}
+	if orderBy == OrderByAsIs && params.cursorMode() {
+		orderBy, key = OrderByAsc, rowKey(orderFieldID)
+	}
+	switch orderBy {
+	case OrderByAsc:
+		sort.Slice(filtered, func(i, j int) bool { return key(filtered[i]).compare(key(filtered[j])) < 0 })
+	case OrderByDesc:
+		sort.Slice(filtered, func(i, j int) bool { return key(filtered[i]).compare(key(filtered[j])) > 0 })
+	}
+
+	// paginate
+	total := len(filtered)
+	offset := params.Offset
+	if cursor != nil {
+		// rows are sorted, so the first row after cursor is found by binary search
+		offset = sort.Search(len(filtered), func(i int) bool {
+			cmp := key(filtered[i]).compare(cursor.Last)
+			if orderBy == OrderByDesc {
+				return cmp < 0
+			}
+			return cmp > 0
+		})
+	}
+	if offset >= len(filtered) {
+		filtered = nil
+	} else {
+		filtered = filtered[offset:]
+	}
+
+	var next string
+	if params.Limit > 0 && params.Limit < len(filtered) {
+		filtered = filtered[:params.Limit]
+		if params.cursorMode() {
+			next = searchCursor{
+				OrderField: params.OrderField,
+				OrderBy:    params.OrderBy,
+				Query:      params.Query,
+				Last:       key(filtered[len(filtered)-1]),
+			}.encode()
+		}
+	}
+
+	// map
+	result := make([]User, len(filtered))
+	for i, r := range filtered {
+		result[i] = r.User()
+	}
+	return &searchPage{Users: result, NextCursor: next, Total: total}, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, a interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if a != nil {
+		d, _ := json.Marshal(a)
+		w.Write(d)
+	}
+}
+
+func writeSearchError(w http.ResponseWriter, code int, err string) {
+	writeJSON(w, code, SearchErrorResponse{Error: err})
+}
+
+// parseSearchRequest reads search parameters from query, it returns error text for SearchErrorResponse
+func parseSearchRequest(r *http.Request) (SearchRequest, string) {
+	q := r.URL.Query()
+	params := SearchRequest{
+		Query:      q.Get("query"),
+		OrderField: q.Get("order_field"),
+		Highlight:  q.Get("highlight") == "1",
+	}
+
+	var err error
+	if s := q.Get("limit"); s != "" {
+		if params.Limit, err = strconv.Atoi(s); err != nil || params.Limit < 0 {
+			return params, errorBadLimit
+		}
+	}
+	if cursor, ok := q["cursor"]; ok {
+		params.UseCursor = true
+		params.Cursor = cursor[0]
+		if q.Get("offset") != "" {
+			return params, errorBadOffset
+		}
+	}
+	if s := q.Get("offset"); s != "" {
+		if params.Offset, err = strconv.Atoi(s); err != nil || params.Offset < 0 {
+			return params, errorBadOffset
+		}
+	}
+	if s := q.Get("order_by"); s != "" {
+		params.OrderBy, err = strconv.Atoi(s)
+		if err != nil || params.OrderBy < OrderByAsc || params.OrderBy > OrderByDesc {
+			return params, errorBadOrderBy
+		}
+	}
+	return params, ""
+}
+
+// ServeHTTP handles search requests in the format of SearchClient
+func (srv *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if srv.AccessToken != "" && r.Header.Get("AccessToken") != srv.AccessToken {
+		writeJSON(w, http.StatusUnauthorized, nil)
+		return
+	}
+
+	mediaType, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		writeSearchError(w, http.StatusNotAcceptable, errorNotAcceptable)
+		return
+	}
+
+	params, errText := parseSearchRequest(r)
+	if errText != "" {
+		writeSearchError(w, http.StatusBadRequest, errText)
+		return
+	}
+
+	page, err := srv.search(params)
+	queryErr := &QueryError{}
+	switch {
+	case errors.As(err, &queryErr):
+		writeSearchError(w, http.StatusBadRequest, ErrorBadQuery+": "+queryErr.Error())
+	case err == ErrBadOrderField:
+		writeSearchError(w, http.StatusBadRequest, ErrorBadOrderField)
+	case err == ErrBadCursor:
+		writeSearchError(w, http.StatusBadRequest, ErrorBadCursor)
+	case err != nil:
+		writeSearchError(w, http.StatusInternalServerError, err.Error())
+	default:
+		if page.NextCursor != "" {
+			w.Header().Set(headerNextCursor, page.NextCursor)
+		}
+		var query QueryNode
+		if params.Highlight {
+			query, _ = ParseQuery(params.Query)
+		}
+		// offset isn't moved in cursor mode, so only cursor tells about the next page
+		nextPage := params.Offset+len(page.Users) < page.Total
+		if params.cursorMode() {
+			nextPage = page.NextCursor != ""
+		}
+		writeUsers(w, r, mediaType, &searchEnvelope{
+			Version: envelopeVersion,
+			Users:   highlightUsers(query, page.Users),
+			Total:   page.Total,
+			Page: pageInfo{
+				Offset:     params.Offset,
+				Limit:      params.Limit,
+				NextPage:   nextPage,
+				NextCursor: page.NextCursor,
+			},
+		})
+	}
+}
+
+// searchResult is user with highlighted fragment of About, old clients ignore Highlight
+type searchResult struct {
+	User
+	Highlight string `json:",omitempty"`
+}
+
+// highlightUsers adds snippets with matches of query to users
+func highlightUsers(query QueryNode, users []User) []searchResult {
+	h := newHighlighter(query)
+	results := make([]searchResult, len(users))
+	for i, u := range users {
+		results[i].User = u
+		if h != nil {
+			results[i].Highlight = h.snippet(u.About)
+		}
+	}
+	return results
+}
+
+// etagMatch checks if etag is in If-None-Match list
+func etagMatch(header, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == etag || value == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeUsers writes users in media type with ETag, it responds 304 if client has the same response
+func writeUsers(w http.ResponseWriter, r *http.Request, mediaType string, env *searchEnvelope) {
+	data, err := encodeResults(mediaType, env)
+	if err != nil {
+		writeSearchError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// cursor header is a part of response too
+	hash := sha1.Sum(append(data, w.Header().Get(headerNextCursor)...))
+	etag := `"` + hex.EncodeToString(hash[:]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
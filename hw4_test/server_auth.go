@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenTTL = time.Hour
+	defaultMaxSkew  = 5 * time.Minute
+)
+
+var (
+	errNoCredentials    = errors.New("no credentials")
+	errBadToken         = errors.New("bad token")
+	errBadSignature     = errors.New("bad signature")
+	errExpiredSignature = errors.New("signature timestamp is out of allowed skew")
+)
+
+// Verifier checks credentials of request to search server
+type Verifier interface {
+	Verify(r *http.Request) error
+}
+
+// RequireAuth is a middleware which responds 401 to requests rejected by verifier
+func RequireAuth(v Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeSearchError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns token from Authorization header
+func bearerToken(r *http.Request) (string, error) {
+	value := r.Header.Get(headerAuthorization)
+	if len(value) < len("Bearer ") || !strings.EqualFold(value[:len("Bearer ")], "Bearer ") {
+		return "", errNoCredentials
+	}
+	return strings.TrimSpace(value[len("Bearer "):]), nil
+}
+
+// BearerVerifier accepts static bearer tokens
+type BearerVerifier struct {
+	Tokens []string
+}
+
+// Verify checks that bearer token is one of v.Tokens
+func (v *BearerVerifier) Verify(r *http.Request) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+	for _, t := range v.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return errBadToken
+}
+
+// TokenIssuer is OAuth2 token endpoint with client credentials grant and Verifier of issued tokens
+type TokenIssuer struct {
+	// client id -> client secret
+	Clients map[string]string
+	// lifetime of tokens, 0 means one hour
+	TTL time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	// current time, it is replaced in tests
+	now func() time.Time
+}
+
+// NewTokenIssuer creates token endpoint for clients
+func NewTokenIssuer(clients map[string]string, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		Clients: clients,
+		TTL:     ttl,
+		tokens:  make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (ti *TokenIssuer) currentTime() time.Time {
+	if ti.now != nil {
+		return ti.now()
+	}
+	return time.Now()
+}
+
+func (ti *TokenIssuer) ttl() time.Duration {
+	if ti.TTL <= 0 {
+		return defaultTokenTTL
+	}
+	return ti.TTL
+}
+
+// clientCredentials reads client id and secret from basic auth or form
+func clientCredentials(r *http.Request) (string, string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		return id, secret
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+}
+
+// ServeHTTP issues token, errors are in the format of RFC 6749
+func (ti *TokenIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	id, secret := clientCredentials(r)
+	expected, ok := ti.Clients[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(secret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	token := hex.EncodeToString(buf)
+
+	ti.mu.Lock()
+	now := ti.currentTime()
+	if ti.tokens == nil {
+		ti.tokens = make(map[string]time.Time)
+	}
+	// tokens which are never verified again are removed here
+	for t, expires := range ti.tokens {
+		if !now.Before(expires) {
+			delete(ti.tokens, t)
+		}
+	}
+	ti.tokens[token] = now.Add(ti.ttl())
+	ti.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: token,
+		TokenType:   "bearer",
+		ExpiresIn:   int(ti.ttl() / time.Second),
+	})
+}
+
+// Verify checks that bearer token is issued and isn't expired
+func (ti *TokenIssuer) Verify(r *http.Request) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	expires, ok := ti.tokens[token]
+	if !ok {
+		return errBadToken
+	}
+	if !ti.currentTime().Before(expires) {
+		delete(ti.tokens, token)
+		return errBadToken
+	}
+	return nil
+}
+
+// Revoke makes all issued tokens invalid
+func (ti *TokenIssuer) Revoke() {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.tokens = make(map[string]time.Time)
+}
+
+// HMACVerifier checks signatures of HMACSigner
+type HMACVerifier struct {
+	// key id -> secret
+	Keys map[string][]byte
+	// max difference between timestamp of signature and server time, 0 means 5 minutes
+	MaxSkew time.Duration
+
+	// current time, it is replaced in tests
+	now func() time.Time
+}
+
+// Verify checks signature and its timestamp
+func (v *HMACVerifier) Verify(r *http.Request) error {
+	keyID := r.Header.Get(headerSignatureKey)
+	timestamp := r.Header.Get(headerSignatureTimestamp)
+	signature := r.Header.Get(headerSignature)
+	if keyID == "" || timestamp == "" || signature == "" {
+		return errNoCredentials
+	}
+
+	secret, ok := v.Keys[keyID]
+	if !ok {
+		return errBadSignature
+	}
+	expected := signRequest(secret, r, timestamp)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errBadSignature
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	now := time.Now
+	if v.now != nil {
+		now = v.now
+	}
+	skew := now().Sub(time.Unix(unix, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	if skew > maxSkew {
+		return errExpiredSignature
+	}
+	return nil
+}
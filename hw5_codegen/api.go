@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // вы можете использовать ApiError в коде, который получается в результате генерации
@@ -29,8 +30,11 @@ const (
 type MyApi struct {
 	statuses map[string]int
 	users    map[string]*User
-	nextID   uint64
-	mu       *sync.RWMutex
+	// login of user by X-Auth token, tokens are fixed,
+	// so users added by Create can't authenticate
+	tokens map[string]string
+	nextID uint64
+	mu     *sync.RWMutex
 }
 
 func NewMyApi() *MyApi {
@@ -47,6 +51,16 @@ func NewMyApi() *MyApi {
 				FullName: "Vasily Romanov",
 				Status:   statusAdmin,
 			},
+			"ivanov": &User{
+				ID:       41,
+				Login:    "ivanov",
+				FullName: "Ivan Ivanov",
+				Status:   statusUser,
+			},
+		},
+		tokens: map[string]string{
+			"100500": "rvasily",
+			"100200": "ivanov",
 		},
 		nextID: 43,
 		mu:     &sync.RWMutex{},
@@ -64,6 +78,11 @@ type CreateParams struct {
 	Age    int    `apivalidator:"min=0,max=128"`
 }
 
+type StatusParams struct {
+	Login  string `apivalidator:"required"`
+	Status string `apivalidator:"required,enum=user|moderator|admin"`
+}
+
 type User struct {
 	ID       uint64 `json:"id"`
 	Login    string `json:"login"`
@@ -118,6 +137,40 @@ func (srv *MyApi) Create(ctx context.Context, in CreateParams) (*NewUser, error)
 	return &NewUser{id}, nil
 }
 
+// Authenticate returns roles of user of X-Auth token,
+// user has roles of his status and of lower ones
+func (srv *MyApi) Authenticate(r *http.Request) ([]string, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	user, exist := srv.users[srv.tokens[r.Header.Get("X-Auth")]]
+	if !exist {
+		return nil, fmt.Errorf("bad token")
+	}
+	roles := make([]string, 0, len(srv.statuses))
+	for role, status := range srv.statuses {
+		if status <= user.Status {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// apigen:api {"url": "/user/status", "method": "POST", "roles": ["admin"]}
+func (srv *MyApi) SetStatus(ctx context.Context, in StatusParams) (*User, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	user, exist := srv.users[in.Login]
+	if !exist {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}
+	}
+	user.Status = srv.statuses[in.Status]
+
+	result := *user
+	return &result, nil
+}
+
 // 2-я часть
 // это похожая структура, с теми же методами, но у них другие параметры!
 // код, созданный вашим кодогенератором работает с конкретной струткурой, про другие ничего не знает
@@ -153,3 +206,63 @@ func (srv *OtherApi) Create(ctx context.Context, in OtherCreateParams) (*OtherUs
 		Level:    in.Level,
 	}, nil
 }
+
+// 3-я часть
+// параметры разных типов, вложенные структуры и json в теле запроса
+
+type TypedApi struct {
+}
+
+func NewTypedApi() *TypedApi {
+	return &TypedApi{}
+}
+
+type Address struct {
+	City   string `apivalidator:"required" json:"city"`
+	Street string `json:"street,omitempty"`
+	Zip    int    `apivalidator:"max=99999" json:"zip,omitempty"`
+}
+
+type TypedParams struct {
+	ID       int64     `apivalidator:"required,min=1" json:"id"`
+	Active   bool      `json:"active"`
+	Rating   float64   `apivalidator:"min=0,max=5,default=2.5" json:"rating"`
+	Birthday time.Time `apivalidator:"default=2000-01-01T00:00:00Z" json:"birthday"`
+	Tags     []string  `apivalidator:"enum=go|rust|c,max=3" json:"tags,omitempty"`
+	Scores   []int     `json:"scores,omitempty"`
+	Address  Address   `apivalidator:"paramname=address" json:"address"`
+}
+
+// apigen:api {"url": "/typed/update", "method": "POST"}
+func (srv *TypedApi) Update(ctx context.Context, in *TypedParams) (*TypedParams, error) {
+	if in.ID == 13 {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("object not exist")}
+	}
+	return in, nil
+}
+
+type SearchParams struct {
+	Email   string    `apivalidator:"required,email" json:"email"`
+	Site    string    `apivalidator:"url" json:"site,omitempty"`
+	Token   string    `apivalidator:"uuid" json:"token,omitempty"`
+	Country string    `apivalidator:"default=RU,len=2" json:"country"`
+	Nick    string    `apivalidator:"required,len=3..16,pattern=^[a-z][a-z0-9_]{2,}$" json:"nick"`
+	PerPage int       `apivalidator:"paramname=per_page,oneof=10|20|50,default=20" json:"per_page"`
+	Page    int       `apivalidator:"min=0,default=0" json:"page"`
+	Labels  []string  `apivalidator:"pattern=^[a-z]+$" json:"labels,omitempty"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+}
+
+// Validate checks fields which depend on each other
+func (in *SearchParams) Validate() error {
+	if !in.From.IsZero() && !in.To.IsZero() && in.To.Before(in.From) {
+		return fmt.Errorf("to must be after from")
+	}
+	return nil
+}
+
+// apigen:api {"url": "/typed/search", "method": "POST"}
+func (srv *TypedApi) Search(ctx context.Context, in SearchParams) (*SearchParams, error) {
+	return &in, nil
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io"
+	"io/ioutil"
 	"log"
-	"os"
 	"strings"
 	"text/template"
 )
@@ -18,23 +22,81 @@ var (
 )
 
 func main() {
+	client := flag.Bool("client", false, "generate typed client of api instead of handlers")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatal("usage: codegen [-client] api.go out.go")
+	}
+	src, dst := flag.Arg(0), flag.Arg(1)
+
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+	node, err := parser.ParseFile(fset, src, nil, parser.ParseComments)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// nested structs can be declared after structs which use them, so all of them are collected first
+	structs := make(map[string]*ast.StructType)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if t, ok := n.(*ast.TypeSpec); ok {
+			if s, ok := t.Type.(*ast.StructType); ok {
+				structs[t.Name.Name] = s
+			}
+		}
+		return true
+	})
+	// structs with Validate() error have struct-level validation,
+	// api structs with Authenticate method check credentials and roles themselves
+	hooks := make(map[string]bool)
+	for _, decl := range node.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil {
+			continue
+		}
+		if isValidateHook(fn) {
+			hooks[getFuncReceiver(fn)] = true
+		}
+		if isAuthenticator(fn) {
+			authenticators[getFuncReceiver(fn)] = true
+		}
+	}
+	params := paramStructs(structs, hooks)
+
+	out := &bytes.Buffer{}
+	if *client {
+		genClient(out, node, params)
+	} else {
+		genHandlers(out, node, params, hooks)
+	}
+
+	// unformatted code is written too to find error in it
+	code, err := format.Source(out.Bytes())
 	if err != nil {
+		log.Printf("generated code is invalid: %v", err)
+		code = out.Bytes()
+	}
+	if err := ioutil.WriteFile(dst, code, 0644); err != nil {
 		log.Fatal(err)
 	}
+	if !*client {
+		if err := writeOpenAPI(dst, node.Name.Name, structs, params); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
 
-	out, _ := os.Create(os.Args[2])
+// genHandlers generates ServeHTTP of api structs, handlers of methods, bind and validate of params
+func genHandlers(out io.Writer, node *ast.File, params map[string][]fieldInfo, hooks map[string]bool) {
 	outTpl.Execute(out, node.Name.Name)
 
 	ast.Inspect(node, func(n ast.Node) bool {
 		switch t := n.(type) {
 		case *ast.FuncDecl:
-			processFunction(out, t)
+			processFunction(out, t, genHandler)
 		case *ast.TypeSpec:
-			if currStruct, ok := t.Type.(*ast.StructType); ok {
-				validateStruct(out, t, currStruct)
-				bindStruct(out, t, currStruct)
+			if fields, ok := params[t.Name.Name]; ok {
+				validateStruct(out, t, fields, hooks[t.Name.Name])
+				bindStruct(out, t, fields)
 			}
 		}
 		return true
@@ -45,9 +107,36 @@ func main() {
 	}
 }
 
-func processFunction(w io.Writer, fn *ast.FuncDecl) {
+// isValidateHook checks that fn is method Validate() error
+func isValidateHook(fn *ast.FuncDecl) bool {
+	if fn.Recv == nil || fn.Name.Name != "Validate" || len(fn.Type.Params.List) != 0 {
+		return false
+	}
+	results := fn.Type.Results
+	if results == nil || len(results.List) != 1 {
+		return false
+	}
+	id, ok := results.List[0].Type.(*ast.Ident)
+	return ok && id.Name == "error"
+}
+
+// isAuthenticator checks that fn is method Authenticate(*http.Request) ([]string, error)
+func isAuthenticator(fn *ast.FuncDecl) bool {
+	if fn.Recv == nil || fn.Name.Name != "Authenticate" {
+		return false
+	}
+	params, results := fn.Type.Params.List, fn.Type.Results
+	if len(params) != 1 || len(params[0].Names) > 1 || results == nil || len(results.List) != 2 {
+		return false
+	}
+	return types.ExprString(params[0].Type) == "*http.Request" &&
+		types.ExprString(results.List[0].Type) == "[]string" &&
+		types.ExprString(results.List[1].Type) == "error"
+}
+
+func processFunction(w io.Writer, fn *ast.FuncDecl, gen func(io.Writer, *ast.FuncDecl, string)) {
 	if fn.Doc != nil && strings.HasPrefix(fn.Doc.Text(), "apigen:api") {
 		comment := strings.TrimSpace(strings.TrimPrefix(fn.Doc.Text(), "apigen:api"))
-		genHandler(w, fn, comment)
+		gen(w, fn, comment)
 	}
 }
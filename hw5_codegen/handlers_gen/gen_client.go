@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// clientMethodInfo is a method of typed client
+type clientMethodInfo struct {
+	*methodInfo
+	// method of request, GET is used if api accepts GET and POST
+	HTTPMethod string
+	ResultType string
+}
+
+var (
+	clientTpl     = template.Must(template.ParseFiles("./templates/client.tpl"))
+	clientFuncTpl = template.Must(template.ParseFiles("./templates/client_func.tpl"))
+
+	clientStructTpl = template.Must(template.New("client").Parse(`
+// {{.Recv}}Client calls methods of {{.Recv}} over HTTP
+type {{.Recv}}Client struct {
+	// URL of server without trailing slash
+	URL string
+	// AuthToken is sent in X-Auth header to methods which need auth
+	AuthToken string
+	// HTTPClient is used for requests, nil means http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func New{{.Recv}}Client(url, authToken string) *{{.Recv}}Client {
+	return &{{.Recv}}Client{URL: url, AuthToken: authToken}
+}
+	`))
+
+	encodeTpl = template.Must(template.New("encode").Parse(`
+	{{if eq .Kind "struct" -}}
+	t.{{.Name}}.encode(values, prefix+"{{.Param}}.")
+	{{else if .Slice -}}
+	for _, v := range t.{{.Name}} {
+		values.Add(prefix+"{{.Param}}", {{.Formatter}}(v))
+	}
+	{{else -}}
+	if !({{.Zero}}) {
+		values.Set(prefix+"{{.Param}}", {{.Formatter}}(t.{{.Name}}))
+	}
+	{{end}}
+	`))
+)
+
+// genClient generates typed clients of api structs and encode of params
+func genClient(out io.Writer, node *ast.File, params map[string][]fieldInfo) {
+	clientTpl.Execute(out, node.Name.Name)
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.FuncDecl:
+			processFunction(out, t, genClientMethod)
+		case *ast.TypeSpec:
+			if fields, ok := params[t.Name.Name]; ok {
+				encodeStruct(out, t, fields)
+			}
+		}
+		return true
+	})
+
+	for _, infos := range serveStructs {
+		clientStructTpl.Execute(out, infos)
+	}
+}
+
+func genClientMethod(w io.Writer, fn *ast.FuncDecl, comment string) {
+	info := parseMethod(fn, comment)
+	addToServe(*info)
+
+	method := strings.Split(info.Method, "|")[0]
+	result := "interface{}"
+	if info.Result != nil {
+		result = types.ExprString(info.Result)
+	}
+	fmt.Printf("%sClient.%s %s %s\n", info.Recv, info.Name, method, info.URL)
+	clientFuncTpl.Execute(w, &clientMethodInfo{methodInfo: info, HTTPMethod: method, ResultType: result})
+}
+
+// encodeStruct generates encode which writes params to query or form,
+// zero values are skipped because server handles them as absent ones
+func encodeStruct(w io.Writer, t *ast.TypeSpec, fields []fieldInfo) {
+	fmt.Printf("generating encode code for %s\n", t.Name.Name)
+	fmt.Fprintf(w, "func (t *%s) encode(values url.Values, prefix string) {", t.Name.Name)
+	for _, f := range fields {
+		if f.Param == "-" {
+			continue
+		}
+		encodeTpl.Execute(w, &tpl{fieldInfo: f})
+	}
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+}
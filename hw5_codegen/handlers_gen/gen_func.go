@@ -15,10 +15,16 @@ type methodInfo struct {
 	URL    string `json:"url"`
 	Auth   bool   `json:"auth,omitempty"`
 	Method string `json:"method,omitempty"`
+	// caller must have one of roles, roles need auth
+	Roles []string `json:"roles,omitempty"`
 	// from reflection
 	Recv  string `json:"recv,omitempty"`
 	Name  string `json:"name,omitempty"`
 	Param string `json:"param,omitempty"`
+	// param is passed by pointer
+	ParamPtr bool `json:"param_ptr,omitempty"`
+	// type of result, it is described in OpenAPI document
+	Result ast.Expr `json:"-"`
 }
 
 type serveInfo struct {
@@ -42,6 +48,8 @@ func (srv *{{.Recv}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	`))
 
 	serveStructs = map[string]serveInfo{}
+	// api structs which implement Authenticator, they are collected before generation
+	authenticators = map[string]bool{}
 )
 
 func getFuncReceiver(fn *ast.FuncDecl) string {
@@ -59,7 +67,8 @@ func getFuncReceiver(fn *ast.FuncDecl) string {
 	return result
 }
 
-func genHandler(w io.Writer, fn *ast.FuncDecl, comment string) {
+// parseMethod returns method information from apigen:api comment and signature
+func parseMethod(fn *ast.FuncDecl, comment string) *methodInfo {
 	// get method information
 	info := &methodInfo{}
 	if err := json.Unmarshal([]byte(comment), info); err != nil {
@@ -69,12 +78,21 @@ func genHandler(w io.Writer, fn *ast.FuncDecl, comment string) {
 	if info.Method == "" {
 		info.Method = http.MethodGet + "|" + http.MethodPost
 	}
+	if len(info.Roles) > 0 {
+		info.Auth = true
+	}
 
 	// fill params
 	var paramType string
+	var paramPtr bool
 	if fn.Type.Params.List != nil {
 		for _, p := range fn.Type.Params.List {
-			switch t := p.Type.(type) {
+			typ := p.Type
+			star, isPtr := typ.(*ast.StarExpr)
+			if isPtr {
+				typ = star.X
+			}
+			switch t := typ.(type) {
 			case *ast.Ident:
 				paramType = t.Name
 			case *ast.SelectorExpr:
@@ -83,16 +101,29 @@ func genHandler(w io.Writer, fn *ast.FuncDecl, comment string) {
 			if paramType == "Context" {
 				continue
 			}
+			paramPtr = isPtr
 		}
 	}
 
 	info.Name = fn.Name.Name
 	info.Recv = getFuncReceiver(fn)
+	// roles are returned only by Authenticator, without it nobody has them
+	if len(info.Roles) > 0 && !authenticators[info.Recv] {
+		log.Fatalf("%s.%s: roles need Authenticate(*http.Request) ([]string, error) method of %s", info.Recv, info.Name, info.Recv)
+	}
 	info.Param = paramType
+	info.ParamPtr = paramPtr
+	if results := fn.Type.Results; results != nil && len(results.List) > 0 {
+		info.Result = results.List[0].Type
+	}
+	return info
+}
 
+func genHandler(w io.Writer, fn *ast.FuncDecl, comment string) {
+	info := parseMethod(fn, comment)
 	addToServe(*info)
 
-	fmt.Printf("%+v\n", info)
+	fmt.Printf("%s.%s %s %s\n", info.Recv, info.Name, info.Method, info.URL)
 	funcTpl.Execute(w, info)
 }
 
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI 3 document, only used parts of specification are described
+
+type openAPI struct {
+	OpenAPI    string                       `json:"openapi"`
+	Info       openAPIInfo                  `json:"info"`
+	Paths      map[string]map[string]*apiOp `json:"paths"`
+	Components openAPIComponents            `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes,omitempty"`
+}
+
+type securityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type apiOp struct {
+	OperationID string                 `json:"operationId"`
+	Parameters  []apiParam             `json:"parameters,omitempty"`
+	RequestBody *apiBody               `json:"requestBody,omitempty"`
+	Responses   map[string]apiResponse `json:"responses"`
+	Security    []map[string][]string  `json:"security,omitempty"`
+	// roles of caller, one of them is required
+	Roles []string `json:"x-roles,omitempty"`
+}
+
+type apiParam struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Explode  bool    `json:"explode,omitempty"`
+	Schema   *schema `json:"schema"`
+}
+
+type apiBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]apiContent `json:"content"`
+}
+
+type apiContent struct {
+	Schema *schema `json:"schema"`
+}
+
+type apiResponse struct {
+	Description string                `json:"description"`
+	Content     map[string]apiContent `json:"content,omitempty"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Default              *json.RawMessage   `json:"default,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int64             `json:"minLength,omitempty"`
+	MaxLength            *int64             `json:"maxLength,omitempty"`
+	MinItems             *int64             `json:"minItems,omitempty"`
+	MaxItems             *int64             `json:"maxItems,omitempty"`
+}
+
+const (
+	authScheme   = "apiKey"
+	errorSchema  = "Error"
+	schemaPrefix = "#/components/schemas/"
+)
+
+// specGen builds document for one api struct
+type specGen struct {
+	doc     *openAPI
+	structs map[string]*ast.StructType
+	params  map[string][]fieldInfo
+}
+
+// openAPIPath returns path of document of api struct next to generated handlers:
+// api_handlers.go -> api_handlers.myapi.openapi.json
+func openAPIPath(out, recv string) string {
+	return strings.TrimSuffix(out, ".go") + "." + strings.ToLower(recv) + ".openapi.json"
+}
+
+// writeOpenAPI writes OpenAPI 3 document for every api struct,
+// they are separate documents because different structs can have the same urls
+func writeOpenAPI(out, pkg string, structs map[string]*ast.StructType, params map[string][]fieldInfo) error {
+	for recv, serve := range serveStructs {
+		g := &specGen{structs: structs, params: params}
+		doc := g.build(pkg+"."+recv, serve)
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := openAPIPath(out, recv)
+		fmt.Printf("writing OpenAPI document of %s to %s\n", recv, path)
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *specGen) build(title string, serve serveInfo) *openAPI {
+	g.doc = &openAPI{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: title, Version: "1.0.0"},
+		Paths:   make(map[string]map[string]*apiOp),
+		Components: openAPIComponents{
+			Schemas: map[string]*schema{
+				errorSchema: {
+					Type:       "object",
+					Properties: map[string]*schema{"error": {Type: "string"}},
+					Required:   []string{"error"},
+				},
+			},
+		},
+	}
+	for _, m := range serve.Methods {
+		if m.Auth {
+			g.doc.Components.SecuritySchemes = map[string]securityScheme{
+				authScheme: authSecurityScheme(serve.Recv),
+			}
+		}
+		ops, ok := g.doc.Paths[m.URL]
+		if !ok {
+			ops = make(map[string]*apiOp)
+			g.doc.Paths[m.URL] = ops
+		}
+		for _, method := range strings.Split(m.Method, "|") {
+			ops[strings.ToLower(method)] = g.operation(m, method)
+		}
+	}
+	return g.doc
+}
+
+func (g *specGen) operation(m methodInfo, method string) *apiOp {
+	op := &apiOp{
+		OperationID: m.Name,
+		Responses: map[string]apiResponse{
+			"200": {
+				Description: "OK",
+				Content: map[string]apiContent{"application/json": {Schema: &schema{
+					Type: "object",
+					Properties: map[string]*schema{
+						"error":    {Type: "string"},
+						"response": g.typeSchema(m.Result),
+					},
+					Required: []string{"error", "response"},
+				}}},
+			},
+			"400": errorResponse("bad params"),
+			"406": errorResponse("bad method"),
+			"500": errorResponse("internal error"),
+		},
+	}
+	if m.Auth {
+		op.Security = []map[string][]string{{authScheme: {}}}
+		op.Responses["403"] = errorResponse("unauthorized")
+		if len(m.Roles) > 0 {
+			op.Roles = m.Roles
+			op.Responses["403"] = errorResponse("unauthorized or forbidden for roles " + strings.Join(m.Roles, ", "))
+		}
+	}
+
+	fields := g.params[m.Param]
+	if method == http.MethodGet {
+		op.Parameters = queryParams("", fields, g.params)
+		return op
+	}
+	body := paramsSchema(fields, g.params)
+	op.RequestBody = &apiBody{
+		Required: len(body.Required) > 0,
+		Content: map[string]apiContent{
+			"application/x-www-form-urlencoded": {Schema: body},
+			"application/json":                  {Schema: body},
+		},
+	}
+	return op
+}
+
+// authSecurityScheme describes credentials of api struct. Credentials of Authenticator
+// can't be found out from code, so it is assumed that it checks X-Auth header too.
+func authSecurityScheme(recv string) securityScheme {
+	scheme := securityScheme{Type: "apiKey", In: "header", Name: "X-Auth"}
+	if authenticators[recv] {
+		scheme.Description = "token is checked by " + recv + ".Authenticate, roles of caller are listed in x-roles of methods"
+	} else {
+		scheme.Description = "fixed token 100500"
+	}
+	return scheme
+}
+
+func errorResponse(description string) apiResponse {
+	return apiResponse{
+		Description: description,
+		Content: map[string]apiContent{
+			"application/json": {Schema: &schema{Ref: schemaPrefix + errorSchema}},
+		},
+	}
+}
+
+// queryParams returns query parameters of GET, fields of nested structs are named with dots
+func queryParams(prefix string, fields []fieldInfo, params map[string][]fieldInfo) []apiParam {
+	result := make([]apiParam, 0, len(fields))
+	for _, f := range fields {
+		if f.Param == "-" {
+			continue
+		}
+		if f.Kind == kindStruct {
+			result = append(result, queryParams(prefix+f.Param+".", params[f.Type], params)...)
+			continue
+		}
+		_, required := f.Rules["required"]
+		result = append(result, apiParam{
+			Name:     prefix + f.Param,
+			In:       "query",
+			Required: required,
+			Explode:  f.Slice,
+			Schema:   fieldSchema(f, params),
+		})
+	}
+	return result
+}
+
+// paramsSchema returns schema of request body, properties are named as params
+func paramsSchema(fields []fieldInfo, params map[string][]fieldInfo) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema, len(fields))}
+	for _, f := range fields {
+		if f.Param == "-" {
+			continue
+		}
+		s.Properties[f.Param] = fieldSchema(f, params)
+		if _, ok := f.Rules["required"]; ok {
+			s.Required = append(s.Required, f.Param)
+		}
+	}
+	return s
+}
+
+// kindSchema returns schema of simple kind
+func kindSchema(kind string) *schema {
+	switch kind {
+	case kindInt:
+		return &schema{Type: "integer"}
+	case kindInt64:
+		return &schema{Type: "integer", Format: "int64"}
+	case kindFloat64:
+		return &schema{Type: "number", Format: "double"}
+	case kindBool:
+		return &schema{Type: "boolean"}
+	case kindTime:
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	return &schema{Type: "string"}
+}
+
+// ruleValue converts value of rule to type of field
+func ruleValue(kind, value string) interface{} {
+	switch kind {
+	case kindInt, kindInt64:
+		v, _ := strconv.ParseInt(value, 10, 64)
+		return v
+	case kindFloat64:
+		v, _ := strconv.ParseFloat(value, 64)
+		return v
+	case kindBool:
+		v, _ := strconv.ParseBool(value)
+		return v
+	}
+	return value
+}
+
+// fieldSchema returns schema of param field with constraints of apivalidator rules
+func fieldSchema(f fieldInfo, params map[string][]fieldInfo) *schema {
+	if f.Kind == kindStruct {
+		return paramsSchema(params[f.Type], params)
+	}
+	elem := kindSchema(f.Kind)
+	s := elem
+	if f.Slice {
+		s = &schema{Type: "array", Items: elem}
+	}
+
+	for _, rule := range []string{"enum", "oneof"} {
+		if v, ok := f.Rules[rule]; ok {
+			for _, item := range strings.Split(v, "|") {
+				elem.Enum = append(elem.Enum, ruleValue(f.Kind, item))
+			}
+		}
+	}
+	if v, ok := f.Rules["pattern"]; ok {
+		elem.Pattern = v
+	}
+	for rule, format := range map[string]string{"email": "email", "url": "uri", "uuid": "uuid"} {
+		if _, ok := f.Rules[rule]; ok {
+			elem.Format = format
+		}
+	}
+	if v, ok := f.Rules["len"]; ok {
+		bounds := strings.SplitN(v, "..", 2)
+		min, _ := strconv.ParseInt(bounds[0], 10, 64)
+		max, _ := strconv.ParseInt(bounds[len(bounds)-1], 10, 64)
+		if f.Slice {
+			s.MinItems, s.MaxItems = &min, &max
+		} else {
+			s.MinLength, s.MaxLength = &min, &max
+		}
+	}
+	if v, ok := f.Rules["default"]; ok {
+		// pointer keeps zero defaults, omitempty drops 0 and false of interface{}
+		data, _ := json.Marshal(ruleValue(f.Kind, v))
+		raw := json.RawMessage(data)
+		s.Default = &raw
+	}
+	for _, rule := range []string{"min", "max"} {
+		v, ok := f.Rules[rule]
+		if !ok {
+			continue
+		}
+		if f.Numeric() {
+			n, _ := strconv.ParseFloat(v, 64)
+			if rule == "min" {
+				s.Minimum = &n
+			} else {
+				s.Maximum = &n
+			}
+			continue
+		}
+		n, _ := strconv.ParseInt(v, 10, 64)
+		switch {
+		case f.Slice && rule == "min":
+			s.MinItems = &n
+		case f.Slice:
+			s.MaxItems = &n
+		case rule == "min":
+			s.MinLength = &n
+		default:
+			s.MaxLength = &n
+		}
+	}
+	return s
+}
+
+// typeSchema returns schema of result type, structs are added to components
+func (g *specGen) typeSchema(expr ast.Expr) *schema {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return g.typeSchema(t.X)
+	case *ast.ArrayType:
+		if id, ok := t.Elt.(*ast.Ident); ok && id.Name == "byte" {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.typeSchema(t.Elt)}
+	case *ast.MapType:
+		return &schema{Type: "object", AdditionalProperties: g.typeSchema(t.Value)}
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Time" {
+			return kindSchema(kindTime)
+		}
+	case *ast.Ident:
+		switch t.Name {
+		case "int", "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32":
+			return &schema{Type: "integer"}
+		case "int64", "uint64":
+			return &schema{Type: "integer", Format: "int64"}
+		case "float32":
+			return &schema{Type: "number", Format: "float"}
+		case "float64":
+			return kindSchema(kindFloat64)
+		case "bool", "string":
+			return kindSchema(t.Name)
+		}
+		if s, ok := g.structs[t.Name]; ok {
+			g.structSchema(t.Name, s)
+			return &schema{Ref: schemaPrefix + t.Name}
+		}
+	}
+	// type of other package or interface can be anything
+	return &schema{}
+}
+
+// structSchema adds schema of struct to components, properties are named as in encoding/json
+func (g *specGen) structSchema(name string, st *ast.StructType) {
+	if _, ok := g.doc.Components.Schemas[name]; ok {
+		return
+	}
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	// placeholder stops recursion of self-referencing structs
+	g.doc.Components.Schemas[name] = s
+	for _, field := range st.Fields.List {
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			tag = reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
+		}
+		jsonName := strings.Split(tag.Get("json"), ",")[0]
+		if jsonName == "-" {
+			continue
+		}
+		for _, fname := range field.Names {
+			if !ast.IsExported(fname.Name) {
+				continue
+			}
+			prop := jsonName
+			if prop == "" {
+				prop = fname.Name
+			}
+			s.Properties[prop] = g.typeSchema(field.Type)
+		}
+	}
+}
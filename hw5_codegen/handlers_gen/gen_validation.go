@@ -4,15 +4,108 @@ import (
 	"fmt"
 	"go/ast"
 	"io"
+	"log"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
+// kinds of fields which can be bound and validated
+const (
+	kindInt     = "int"
+	kindInt64   = "int64"
+	kindFloat64 = "float64"
+	kindBool    = "bool"
+	kindString  = "string"
+	kindTime    = "time"
+	kindStruct  = "struct"
+)
+
+// fieldInfo describes field of param struct
+type fieldInfo struct {
+	// name of field
+	Name string
+	// name of parameter in query, form or json, "-" means field isn't bound
+	Param string
+	// kind of field or of element of slice
+	Kind string
+	// go type of field or of element of slice
+	Type  string
+	Slice bool
+	// parsed apivalidator tag
+	Rules map[string]string
+}
+
+// ErrName is a name of field in errors
+func (f fieldInfo) ErrName() string {
+	return strings.ToLower(f.Name)
+}
+
+// TypeName is a name of type in errors
+func (f fieldInfo) TypeName() string {
+	switch f.Kind {
+	case kindInt64:
+		return kindInt
+	case kindFloat64:
+		return "float"
+	case kindTime:
+		return "time in RFC3339 format"
+	}
+	return f.Kind
+}
+
+// Parser is a name of function of generated code which parses text of parameter
+func (f fieldInfo) Parser() string {
+	switch f.Kind {
+	case kindTime:
+		return "parseTime"
+	case kindFloat64:
+		return "parseFloat64"
+	case kindInt:
+		return "parseInt"
+	case kindInt64:
+		return "parseInt64"
+	case kindBool:
+		return "parseBool"
+	}
+	return "parseString"
+}
+
+// Formatter is a name of function of generated client which formats parameter as text
+func (f fieldInfo) Formatter() string {
+	return "format" + strings.TrimPrefix(f.Parser(), "parse")
+}
+
+// Zero is an expression which checks that field has default value
+func (f fieldInfo) Zero() string {
+	switch {
+	case f.Slice:
+		return fmt.Sprintf("len(t.%s) == 0", f.Name)
+	case f.Kind == kindString:
+		return fmt.Sprintf("t.%s == \"\"", f.Name)
+	case f.Kind == kindBool:
+		return fmt.Sprintf("!t.%s", f.Name)
+	case f.Kind == kindTime:
+		return fmt.Sprintf("t.%s.IsZero()", f.Name)
+	}
+	return fmt.Sprintf("t.%s == 0", f.Name)
+}
+
+// Numeric checks if field is compared by value in min and max, other ones are compared by length
+func (f fieldInfo) Numeric() bool {
+	return !f.Slice && (f.Kind == kindInt || f.Kind == kindInt64 || f.Kind == kindFloat64)
+}
+
 type tpl struct {
-	FieldName string
-	FieldType string
-	TagValue  string
+	fieldInfo
+	TagValue string
+	// expression of checked value: field or element of slice
+	Value string
+	// parsed parts of TagValue
+	Args []string
 }
 
 var (
@@ -22,90 +115,227 @@ var (
 			arr := strings.Split(s, "|")
 			return strings.Join(arr, ", ")
 		},
+		// literals returns values of enum as go literals for case of switch
+		"literals": func(kind, s string) string {
+			arr := strings.Split(s, "|")
+			if kind == kindString {
+				for i, v := range arr {
+					arr[i] = strconv.Quote(v)
+				}
+			}
+			return strings.Join(arr, ", ")
+		},
+		"quote": strconv.Quote,
 	}
 
 	requiredTpl = template.Must(template.New("required").Funcs(fnMap).Parse(`
-	{{if eq .FieldType "int" -}}
-	if t.{{.FieldName}} == 0 {
-	{{else -}}
-	if t.{{.FieldName}} == "" {
-	{{end -}}
+	if {{.Zero}} {
 		return ApiError{
-			HTTPStatus: http.StatusBadRequest, 
-			Err: fmt.Errorf("{{lower .FieldName}} must me not empty"),
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must me not empty"),
 		}
 	}
 	`))
 
 	minTpl = template.Must(template.New("min").Funcs(fnMap).Parse(`
-	{{if eq .FieldType "int" -}}
-	if t.{{.FieldName}} < {{.TagValue}} {
+	{{if .Numeric -}}
+	if t.{{.Name}} < {{.TagValue}} {
 		return ApiError{
-			HTTPStatus: http.StatusBadRequest, 
-			Err: fmt.Errorf("{{lower .FieldName}} must be >= {{.TagValue}}"),
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be >= {{.TagValue}}"),
 		}
 	}
 	{{else -}}
-	if len(t.{{.FieldName}}) < {{.TagValue}} {
+	if len(t.{{.Name}}) < {{.TagValue}} {
 		return ApiError{
-			HTTPStatus: http.StatusBadRequest, 
-			Err: fmt.Errorf("{{lower .FieldName}} len must be >= {{.TagValue}}"),
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} len must be >= {{.TagValue}}"),
 		}
 	}
-	{{end}}	 
+	{{end}}
 	`))
 
 	maxTpl = template.Must(template.New("max").Funcs(fnMap).Parse(`
-	if t.{{.FieldName}} > {{.TagValue}} {
+	{{if .Numeric -}}
+	if t.{{.Name}} > {{.TagValue}} {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be <= {{.TagValue}}"),
+		}
+	}
+	{{else -}}
+	if len(t.{{.Name}}) > {{.TagValue}} {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} len must be <= {{.TagValue}}"),
+		}
+	}
+	{{end}}
+	`))
+
+	lenTpl = template.Must(template.New("len").Funcs(fnMap).Parse(`
+	{{if eq (len .Args) 2 -}}
+	if n := len(t.{{.Name}}); n < {{index .Args 0}} || n > {{index .Args 1}} {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} len must be in [{{index .Args 0}}, {{index .Args 1}}]"),
+		}
+	}
+	{{else -}}
+	if len(t.{{.Name}}) != {{.TagValue}} {
 		return ApiError{
-			HTTPStatus: http.StatusBadRequest, 
-			Err: fmt.Errorf("{{lower .FieldName}} must be <= {{.TagValue}}"),
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} len must be {{.TagValue}}"),
 		}
-	}	 
+	}
+	{{end}}
 	`))
 
+	// enum of strings and oneof of ints
 	enumTpl = template.Must(template.New("enum").Funcs(fnMap).Parse(`
-	// dirty hack for enums
-	if !strings.Contains("|{{.TagValue}}|", "|"+t.{{.FieldName}}+"|") {
+	switch {{.Value}} {
+	case {{literals .Kind .TagValue}}:
+	default:
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be one of [{{enum .TagValue}}]"),
+		}
+	}
+	`))
+
+	// pattern uses regexp compiled in var of struct, see patternVar
+	patternTpl = template.Must(template.New("pattern").Funcs(fnMap).Parse(`
+	if {{.Value}} != "" && !{{index .Args 0}}.MatchString({{.Value}}) {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: errors.New({{quote (print .ErrName " must match pattern " .TagValue)}}),
+		}
+	}
+	`))
+
+	emailTpl = template.Must(template.New("email").Parse(`
+	if {{.Value}} != "" && !emailRegexp.MatchString({{.Value}}) {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be valid email"),
+		}
+	}
+	`))
+
+	urlTpl = template.Must(template.New("url").Parse(`
+	if {{.Value}} != "" && !isURL({{.Value}}) {
 		return ApiError{
-			HTTPStatus: http.StatusBadRequest, 
-			Err: fmt.Errorf("{{lower .FieldName}} must be one of [{{enum .TagValue}}]"),
-		} 
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be valid url"),
+		}
+	}
+	`))
+
+	uuidTpl = template.Must(template.New("uuid").Parse(`
+	if {{.Value}} != "" && !uuidRegexp.MatchString({{.Value}}) {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be valid uuid"),
+		}
 	}
 	`))
 
 	defaultTpl = template.Must(template.New("default").Parse(`
-	{{if eq .FieldType "int" -}}
-	if t.{{.FieldName}} == 0 {
-	{{else -}}
-	if t.{{.FieldName}} == "" {
-	{{end -}}
-		t.{{.FieldName}} = "{{.TagValue}}"
+	if {{.Zero}} {
+		{{if eq .Kind "string" -}}
+		t.{{.Name}} = {{printf "%q" .TagValue}}
+		{{- else if eq .Kind "time" -}}
+		t.{{.Name}} = mustParseTime({{printf "%q" .TagValue}})
+		{{- else -}}
+		t.{{.Name}} = {{.TagValue}}
+		{{- end}}
+	}
+	`))
+
+	nestedValidateTpl = template.Must(template.New("nested").Parse(`
+	if err := t.{{.Name}}.validate(); err != nil {
+		return prefixError("{{.ErrName}}", err)
+	}
+	`))
+
+	hookTpl = template.Must(template.New("hook").Parse(`
+	// struct-level validation
+	if err := t.Validate(); err != nil {
+		if _, ok := err.(ApiError); ok {
+			return err
+		}
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: err,
+		}
 	}
 	`))
 
 	bindTpl = template.Must(template.New("bind").Funcs(fnMap).Parse(`
-	{{if eq .FieldType "int" -}} 
-	{{.FieldName}}, err := strconv.Atoi(q.Get("{{.TagValue}}")) 
-	if err != nil {
+	{{if eq .Kind "struct" -}}
+	if sub, ok, err := src.sub("{{.Param}}"); err != nil {
 		return ApiError{
 			HTTPStatus: http.StatusBadRequest,
-			Err: fmt.Errorf("{{lower .FieldName}} must be int"),
+			Err: fmt.Errorf("{{.ErrName}} must be object"),
+		}
+	} else if ok {
+		if err := t.{{.Name}}.bind(sub); err != nil {
+			return prefixError("{{.ErrName}}", err)
 		}
 	}
-	t.{{.FieldName}} = {{.FieldName}}
-	{{else -}} 
-	t.{{.FieldName}} = q.Get("{{.TagValue}}") 
+	{{else if .Slice -}}
+	if values, ok, err := src.values("{{.Param}}"); err != nil {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be list of {{.TypeName}}"),
+		}
+	} else if ok {
+		t.{{.Name}} = make([]{{.Type}}, len(values))
+		for i, value := range values {
+			v, err := {{.Parser}}(value)
+			if err != nil {
+				return ApiError{
+					HTTPStatus: http.StatusBadRequest,
+					Err: fmt.Errorf("{{.ErrName}} must be list of {{.TypeName}}"),
+				}
+			}
+			t.{{.Name}}[i] = v
+		}
+	}
+	{{else -}}
+	if value, ok, err := src.value("{{.Param}}"); err != nil {
+		return ApiError{
+			HTTPStatus: http.StatusBadRequest,
+			Err: fmt.Errorf("{{.ErrName}} must be {{.TypeName}}"),
+		}
+	} else if ok {
+		v, err := {{.Parser}}(value)
+		if err != nil {
+			return ApiError{
+				HTTPStatus: http.StatusBadRequest,
+				Err: fmt.Errorf("{{.ErrName}} must be {{.TypeName}}"),
+			}
+		}
+		t.{{.Name}} = v
+	}
 	{{end}}
 	`))
 )
 
 func getTagValues(tag string) map[string]string {
 	values := make(map[string]string, 6)
+	if tag == "" {
+		return values
+	}
 	// split tag value to kv by , and =
 	arr := strings.Split(tag, ",")
-	for _, value := range arr {
-		arr := strings.Split(value, "=")
+	for i, value := range arr {
+		// pattern can contain commas, so it takes the rest of tag
+		if strings.HasPrefix(value, "pattern=") {
+			values["pattern"] = strings.TrimPrefix(strings.Join(arr[i:], ","), "pattern=")
+			break
+		}
+		arr := strings.SplitN(value, "=", 2)
 		if len(arr) == 1 {
 			values[arr[0]] = ""
 		} else {
@@ -115,115 +345,280 @@ func getTagValues(tag string) map[string]string {
 	return values
 }
 
-func validateField(w io.Writer, t *ast.TypeSpec, fname, ftype, tag string) {
-	values := getTagValues(tag)
-
-	// order of validation
-	templates := [...]struct {
-		name string
-		tpl  *template.Template
-	}{
-		{"default", defaultTpl},
-		{"required", requiredTpl},
-		{"min", minTpl},
-		{"max", maxTpl},
-		{"enum", enumTpl},
-	}
-
-	for _, info := range templates {
-		if val, ok := values[info.name]; ok {
-			fmt.Printf("generating validation code for %s.%s [%s]\n", t.Name.Name, fname, info.name)
-			data := &tpl{FieldName: fname, FieldType: ftype, TagValue: val}
-			info.tpl.Execute(w, data)
+// fieldKind returns kind and go type of field type, slice of simple types is supported
+func fieldKind(expr ast.Expr, structs map[string]*ast.StructType) (kind, typ string, slice, ok bool) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case kindInt, kindInt64, kindFloat64, kindBool, kindString:
+			return t.Name, t.Name, false, true
+		}
+		if _, ok := structs[t.Name]; ok {
+			return kindStruct, t.Name, false, true
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Time" {
+			return kindTime, "time.Time", false, true
+		}
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return "", "", false, false
 		}
+		kind, typ, slice, ok := fieldKind(t.Elt, structs)
+		if !ok || slice || kind == kindStruct {
+			return "", "", false, false
+		}
+		return kind, typ, true, true
 	}
+	return "", "", false, false
 }
 
-func validateStruct(w io.Writer, t *ast.TypeSpec, s *ast.StructType) {
-	needGenerate := false
-	type fieldInfo struct {
-		typ string
-		tag string
-	}
-	fields := make(map[string]fieldInfo)
-
+// structFields returns supported fields of struct in order of declaration
+func structFields(name string, s *ast.StructType, structs map[string]*ast.StructType) []fieldInfo {
+	fields := make([]fieldInfo, 0, len(s.Fields.List))
 	for _, field := range s.Fields.List {
-		fname := field.Names[0].Name
-		typ, ok := field.Type.(*ast.Ident)
+		if len(field.Names) == 0 {
+			fmt.Printf("SKIP embedded %s.%T\n", name, field.Type)
+			continue
+		}
+		kind, typ, slice, ok := fieldKind(field.Type, structs)
 		if !ok {
-			fmt.Printf("SKIP %s.%s %T is not simple type like as int, string\n", t.Name.Name, fname, field.Type)
-			return
+			fmt.Printf("SKIP %s.%s %T is not supported type\n", name, field.Names[0].Name, field.Type)
+			continue
 		}
-		ftype := typ.Name
 
+		var tagValue string
 		if field.Tag != nil {
 			tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-			tagValue, ok := tag.Lookup("apivalidator")
-			needGenerate = needGenerate || ok
-			if ok {
-				fields[fname] = fieldInfo{ftype, tagValue}
+			tagValue = tag.Get("apivalidator")
+		}
+		for _, fname := range field.Names {
+			info := fieldInfo{
+				Name:  fname.Name,
+				Kind:  kind,
+				Type:  typ,
+				Slice: slice,
+				Rules: getTagValues(tagValue),
+			}
+			info.Param = info.Rules["paramname"]
+			if info.Param == "" {
+				info.Param = strings.ToLower(fname.Name)
 			}
+			checkRules(name, info)
+			fields = append(fields, info)
 		}
 	}
+	return fields
+}
 
-	if needGenerate {
-		fmt.Fprintf(w, "func (t %s) validate() error {", t.Name.Name)
-		for k, v := range fields {
-			validateField(w, t, k, v.typ, v.tag)
+// checkRules stops generation if rule can't be applied to field
+func checkRules(structName string, f fieldInfo) {
+	fail := func(rule, reason string) {
+		log.Fatalf("%s.%s: rule %s %s", structName, f.Name, rule, reason)
+	}
+	for rule, value := range f.Rules {
+		switch rule {
+		case "paramname":
+		case "required":
+			if f.Kind == kindStruct {
+				fail(rule, "is not supported for structs")
+			}
+		case "min", "max":
+			if !f.Numeric() && !f.Slice && f.Kind != kindString {
+				fail(rule, "is not supported for "+f.Type)
+			}
+			var err error
+			if f.Numeric() && f.Kind == kindFloat64 {
+				_, err = strconv.ParseFloat(value, 64)
+			} else {
+				_, err = strconv.ParseInt(value, 10, 64)
+			}
+			if err != nil {
+				fail(rule, "has bad value "+value)
+			}
+		case "len":
+			if !f.Slice && f.Kind != kindString {
+				fail(rule, "is supported only for strings and slices")
+			}
+			for _, n := range strings.SplitN(value, "..", 2) {
+				if _, err := strconv.ParseUint(n, 10, 32); err != nil {
+					fail(rule, "has bad value "+value)
+				}
+			}
+		case "enum":
+			if f.Kind != kindString {
+				fail(rule, "is supported only for strings")
+			}
+		case "oneof":
+			if f.Kind != kindInt && f.Kind != kindInt64 {
+				fail(rule, "is supported only for ints")
+			}
+			for _, n := range strings.Split(value, "|") {
+				if _, err := strconv.ParseInt(n, 10, 64); err != nil {
+					fail(rule, "has bad value "+value)
+				}
+			}
+		case "pattern":
+			if f.Kind != kindString {
+				fail(rule, "is supported only for strings")
+			}
+			if _, err := regexp.Compile(value); err != nil {
+				fail(rule, "has bad value: "+err.Error())
+			}
+		case "email", "url", "uuid":
+			if f.Kind != kindString {
+				fail(rule, "is supported only for strings")
+			}
+			if value != "" {
+				fail(rule, "has no value")
+			}
+		case "default":
+			var err error
+			switch {
+			case f.Slice || f.Kind == kindStruct:
+				err = fmt.Errorf("is not supported for %s", f.Type)
+			case f.Kind == kindInt || f.Kind == kindInt64:
+				_, err = strconv.ParseInt(value, 10, 64)
+			case f.Kind == kindFloat64:
+				_, err = strconv.ParseFloat(value, 64)
+			case f.Kind == kindBool:
+				_, err = strconv.ParseBool(value)
+			case f.Kind == kindTime:
+				_, err = time.Parse(time.RFC3339, value)
+			}
+			if err != nil {
+				fail(rule, "has bad value "+value)
+			}
+		default:
+			fail(rule, "is unknown")
 		}
-		fmt.Fprintln(w, "return nil")
-		fmt.Fprintln(w, "}")
-		fmt.Fprintln(w)
 	}
 }
 
-func bindField(w io.Writer, fname, ftype, tag string) {
-	values := getTagValues(tag)
-
-	paramname, ok := values["paramname"]
-	if !ok || paramname == "" {
-		paramname = fname
+// paramStructs returns fields of structs which need bind and validate:
+// structs with apivalidator tags or validation hooks and structs nested in them
+func paramStructs(structs map[string]*ast.StructType, hooks map[string]bool) map[string][]fieldInfo {
+	result := make(map[string][]fieldInfo)
+	var add func(name string)
+	add = func(name string) {
+		if _, ok := result[name]; ok {
+			return
+		}
+		fields := structFields(name, structs[name], structs)
+		result[name] = fields
+		for _, f := range fields {
+			if f.Kind == kindStruct {
+				add(f.Type)
+			}
+		}
 	}
-	if paramname == "-" {
-		return
+
+	for name, s := range structs {
+		if hooks[name] {
+			add(name)
+			continue
+		}
+		for _, field := range s.Fields.List {
+			if field.Tag == nil {
+				continue
+			}
+			tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
+			if _, ok := tag.Lookup("apivalidator"); ok {
+				add(name)
+				break
+			}
+		}
 	}
+	return result
+}
 
-	data := &tpl{FieldName: fname, FieldType: ftype, TagValue: strings.ToLower(paramname)}
-	bindTpl.Execute(w, data)
+// patternVar is a name of var with compiled pattern of field
+func patternVar(structName string, f fieldInfo) string {
+	return "pattern" + structName + f.Name
 }
 
-func bindStruct(w io.Writer, t *ast.TypeSpec, s *ast.StructType) {
-	needGenerate := false
-	type fieldInfo struct {
-		typ string
-		tag string
+func validateField(w io.Writer, structName string, f fieldInfo) {
+	// order of validation, rules of elements are checked for every element of slice
+	templates := [...]struct {
+		name    string
+		tpl     *template.Template
+		element bool
+	}{
+		{"default", defaultTpl, false},
+		{"required", requiredTpl, false},
+		{"min", minTpl, false},
+		{"max", maxTpl, false},
+		{"len", lenTpl, false},
+		{"enum", enumTpl, true},
+		{"oneof", enumTpl, true},
+		{"pattern", patternTpl, true},
+		{"email", emailTpl, true},
+		{"url", urlTpl, true},
+		{"uuid", uuidTpl, true},
 	}
-	fields := make(map[string]fieldInfo)
 
-	for _, field := range s.Fields.List {
-		fname := field.Names[0].Name
-		typ, ok := field.Type.(*ast.Ident)
+	for _, info := range templates {
+		val, ok := f.Rules[info.name]
 		if !ok {
-			fmt.Printf("SKIP %s.%s %T is not simple type like as int, string\n", t.Name.Name, fname, field.Type)
-			return
+			continue
 		}
-		ftype := typ.Name
-		if field.Tag != nil {
-			tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-			tagValue, ok := tag.Lookup("apivalidator")
-			needGenerate = needGenerate || ok
-			fields[fname] = fieldInfo{ftype, tagValue}
+		fmt.Printf("generating validation code for %s.%s [%s]\n", structName, f.Name, info.name)
+		data := &tpl{fieldInfo: f, TagValue: val, Value: "t." + f.Name}
+		switch info.name {
+		case "len":
+			data.Args = strings.SplitN(val, "..", 2)
+		case "pattern":
+			data.Args = []string{patternVar(structName, f)}
 		}
+		if info.element && f.Slice {
+			data.Value = "v"
+			fmt.Fprintf(w, "for _, v := range t.%s {", f.Name)
+			info.tpl.Execute(w, data)
+			fmt.Fprintln(w, "}")
+			continue
+		}
+		info.tpl.Execute(w, data)
+	}
+	if f.Kind == kindStruct {
+		nestedValidateTpl.Execute(w, &tpl{fieldInfo: f})
 	}
+}
 
-	if needGenerate {
-		fmt.Printf("generating bind code for %s\n", t.Name.Name)
-		fmt.Fprintf(w, "func (t *%s) bind(q url.Values) error {", t.Name.Name)
-		for k, v := range fields {
-			bindField(w, k, v.typ, v.tag)
+// validateStruct generates validate of struct, hook means that struct has Validate() error
+// which is called after validation of fields
+func validateStruct(w io.Writer, t *ast.TypeSpec, fields []fieldInfo, hook bool) {
+	for _, f := range fields {
+		if pattern, ok := f.Rules["pattern"]; ok {
+			fmt.Fprintf(w, "var %s = regexp.MustCompile(%q)\n\n", patternVar(t.Name.Name, f), pattern)
 		}
-		fmt.Fprintln(w, "return nil")
-		fmt.Fprintln(w, "}")
-		fmt.Fprintln(w)
 	}
+	fmt.Fprintf(w, "func (t *%s) validate() error {", t.Name.Name)
+	for _, f := range fields {
+		validateField(w, t.Name.Name, f)
+	}
+	if hook {
+		fmt.Printf("generating validation hook call for %s\n", t.Name.Name)
+		hookTpl.Execute(w, nil)
+	}
+	fmt.Fprintln(w, "return nil")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+}
+
+func bindField(w io.Writer, f fieldInfo) {
+	if f.Param == "-" {
+		return
+	}
+	bindTpl.Execute(w, &tpl{fieldInfo: f})
+}
+
+func bindStruct(w io.Writer, t *ast.TypeSpec, fields []fieldInfo) {
+	fmt.Printf("generating bind code for %s\n", t.Name.Name)
+	fmt.Fprintf(w, "func (t *%s) bind(src paramSource) error {", t.Name.Name)
+	for _, f := range fields {
+		bindField(w, f)
+	}
+	fmt.Fprintln(w, "return nil")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
 }
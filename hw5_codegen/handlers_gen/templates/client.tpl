@@ -0,0 +1,90 @@
+package {{.}}
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientResponse is an envelope of responses of api
+type clientResponse struct {
+	Error    string      `json:"error"`
+	Response interface{} `json:"response"`
+}
+
+// callApi sends params in query for GET and in form otherwise and decodes response to out.
+// Error of api is returned as ApiError with status of response
+func callApi(ctx context.Context, client *http.Client, method, endpoint, authToken string, values url.Values, out interface{}) error {
+	var body io.Reader
+	if method == http.MethodGet {
+		endpoint += "?" + values.Encode()
+	} else {
+		body = strings.NewReader(values.Encode())
+	}
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if authToken != "" {
+		req.Header.Set("X-Auth", authToken)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result := clientResponse{Response: out}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ApiError{
+			HTTPStatus: resp.StatusCode,
+			Err:        fmt.Errorf("cant unpack response: %v", err),
+		}
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return ApiError{
+			HTTPStatus: resp.StatusCode,
+			Err:        errors.New(result.Error),
+		}
+	}
+	return nil
+}
+
+func formatString(v string) string {
+	return v
+}
+
+func formatInt(v int) string {
+	return strconv.Itoa(v)
+}
+
+func formatInt64(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func formatFloat64(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatBool(v bool) string {
+	return strconv.FormatBool(v)
+}
+
+func formatTime(v time.Time) string {
+	return v.Format(time.RFC3339Nano)
+}
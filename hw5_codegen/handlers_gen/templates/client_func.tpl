@@ -0,0 +1,15 @@
+// {{.Name}} calls {{.HTTPMethod}} {{.URL}}
+func (c *{{.Recv}}Client) {{.Name}}(ctx context.Context, in {{if .ParamPtr}}*{{end}}{{.Param}}) ({{.ResultType}}, error) {
+	values := url.Values{}
+	{{if .ParamPtr -}}
+	if in != nil {
+		in.encode(values, "")
+	}
+	{{- else -}}
+	in.encode(values, "")
+	{{- end}}
+	var out {{.ResultType}}
+	err := callApi(ctx, c.HTTPClient, "{{.HTTPMethod}}", c.URL+"{{.URL}}", {{if .Auth}}c.AuthToken{{else}}""{{end}}, values, &out)
+	return out, err
+}
+
@@ -7,24 +7,20 @@ func (srv *{{.Recv}}) handle{{.Name}}(w http.ResponseWriter, r *http.Request) {
 	}
 	{{if .Auth -}}
 	// check authorization
-	if r.Header.Get("X-Auth") != "100500" {
-		body := response{Error: "unauthorized"}
-		http.Error(w, body.String(), http.StatusForbidden)
+	if err := authorize(srv, r{{range .Roles}}, {{printf "%q" .}}{{end}}); err != nil {
+		err := err.(ApiError)
+		body := response{Error: err.Error()}
+		http.Error(w, body.String(), err.HTTPStatus)
 		return
 	}
 	{{end -}}
 
-	// bind values. If GET - get from query, if POST - get from form
-	var values url.Values
-	if r.Method == http.MethodGet {
-		values = r.URL.Query()
-	} else {
-		r.ParseForm()
-		values = r.Form
-	}
+	// bind values. If GET - get from query, if json - get from body, otherwise - get from form
 	param := new({{.Param}})
-	err := param.bind(values)
-
+	src, err := requestSource(r)
+	if err == nil {
+		err = param.bind(src)
+	}
 	if err != nil {
 		err := err.(ApiError)
 		body := response{Error: err.Error()}
@@ -40,7 +36,7 @@ func (srv *{{.Recv}}) handle{{.Name}}(w http.ResponseWriter, r *http.Request) {
 		return
 	}	
 	// 
-	res, err := srv.{{.Name}}(context.Background(), *param)
+	res, err := srv.{{.Name}}(context.Background(), {{if not .ParamPtr}}*{{end}}param)
 	if err != nil {
 		body := response{Error: err.Error()}
 		if err, ok := err.(ApiError); ok {
@@ -3,11 +3,15 @@ package {{.}}
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type response struct {
@@ -19,3 +23,232 @@ func (r *response) String() string {
 	data, _ := json.Marshal(r)
 	return string(data)
 }
+
+// Authenticator can be implemented by api struct to check credentials of requests to methods with auth,
+// it returns roles of caller. Api structs without it accept X-Auth header with 100500.
+type Authenticator interface {
+	Authenticate(r *http.Request) ([]string, error)
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+type headerAuth struct{}
+
+func (headerAuth) Authenticate(r *http.Request) ([]string, error) {
+	if r.Header.Get("X-Auth") != "100500" {
+		return nil, errUnauthorized
+	}
+	return nil, nil
+}
+
+// authorize checks credentials of request and that caller has one of roles, empty roles means any caller
+func authorize(api interface{}, r *http.Request, roles ...string) error {
+	auth, ok := api.(Authenticator)
+	if !ok {
+		auth = headerAuth{}
+	}
+	callerRoles, err := auth.Authenticate(r)
+	if err != nil {
+		if ae, ok := err.(ApiError); ok {
+			return ae
+		}
+		return ApiError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errUnauthorized,
+		}
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	for _, role := range roles {
+		for _, callerRole := range callerRoles {
+			if role == callerRole {
+				return nil
+			}
+		}
+	}
+	return ApiError{
+		HTTPStatus: http.StatusForbidden,
+		Err:        fmt.Errorf("forbidden"),
+	}
+}
+
+// paramSource is a source of parameters of request: query, form or json body
+type paramSource interface {
+	// value returns scalar parameter, ok is false if it is absent
+	value(name string) (string, bool, error)
+	// values returns list parameter
+	values(name string) ([]string, bool, error)
+	// sub returns source of nested struct
+	sub(name string) (paramSource, bool, error)
+}
+
+// formSource reads query or form, fields of nested structs are named with dots: address.city
+type formSource struct {
+	form   url.Values
+	prefix string
+}
+
+func (s formSource) value(name string) (string, bool, error) {
+	v := s.form[s.prefix+name]
+	if len(v) == 0 {
+		return "", false, nil
+	}
+	return v[0], true, nil
+}
+
+func (s formSource) values(name string) ([]string, bool, error) {
+	v, ok := s.form[s.prefix+name]
+	return v, ok, nil
+}
+
+func (s formSource) sub(name string) (paramSource, bool, error) {
+	prefix := s.prefix + name + "."
+	for key := range s.form {
+		if strings.HasPrefix(key, prefix) {
+			return formSource{form: s.form, prefix: prefix}, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+var errNotScalar = errors.New("value is not scalar")
+
+// jsonSource reads object of json body, null is the same as absent field
+type jsonSource map[string]json.RawMessage
+
+func (s jsonSource) raw(name string) (json.RawMessage, bool) {
+	v, ok := s[name]
+	if !ok || string(v) == "null" {
+		return nil, false
+	}
+	return v, true
+}
+
+// jsonScalar returns string, number or bool as text
+func jsonScalar(raw json.RawMessage) (string, error) {
+	switch raw[0] {
+	case '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case '{', '[', 'n':
+		return "", errNotScalar
+	}
+	return string(raw), nil
+}
+
+func (s jsonSource) value(name string) (string, bool, error) {
+	raw, ok := s.raw(name)
+	if !ok {
+		return "", false, nil
+	}
+	v, err := jsonScalar(raw)
+	return v, true, err
+}
+
+func (s jsonSource) values(name string) ([]string, bool, error) {
+	raw, ok := s.raw(name)
+	if !ok {
+		return nil, false, nil
+	}
+	items := []json.RawMessage{}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, true, err
+	}
+	result := make([]string, len(items))
+	for i, item := range items {
+		v, err := jsonScalar(item)
+		if err != nil {
+			return nil, true, err
+		}
+		result[i] = v
+	}
+	return result, true, nil
+}
+
+func (s jsonSource) sub(name string) (paramSource, bool, error) {
+	raw, ok := s.raw(name)
+	if !ok {
+		return nil, false, nil
+	}
+	obj := jsonSource{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, true, err
+	}
+	return obj, true, nil
+}
+
+// requestSource returns query for GET, json body for application/json and form otherwise
+func requestSource(r *http.Request) (paramSource, error) {
+	if r.Method == http.MethodGet {
+		return formSource{form: r.URL.Query()}, nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		src := jsonSource{}
+		if err := json.NewDecoder(r.Body).Decode(&src); err != nil {
+			return nil, ApiError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("bad json body"),
+			}
+		}
+		return src, nil
+	}
+	r.ParseForm()
+	return formSource{form: r.Form}, nil
+}
+
+// prefixError adds name of nested struct to validation error
+func prefixError(prefix string, err error) error {
+	if ae, ok := err.(ApiError); ok {
+		return ApiError{
+			HTTPStatus: ae.HTTPStatus,
+			Err:        fmt.Errorf("%s.%s", prefix, ae.Err),
+		}
+	}
+	return err
+}
+
+var (
+	emailRegexp = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+	uuidRegexp  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// isURL checks that s is absolute url with host
+func isURL(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func parseString(s string) (string, error) {
+	return s, nil
+}
+
+func parseInt(s string) (int, error) {
+	return strconv.Atoi(s)
+}
+
+func parseInt64(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func parseFloat64(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseBool(s string) (bool, error) {
+	return strconv.ParseBool(s)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339, s)
+}
+
+// mustParseTime parses default values which are checked by generator
+func mustParseTime(s string) time.Time {
+	t, err := parseTime(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
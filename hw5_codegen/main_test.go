@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -24,7 +25,9 @@ type Case struct {
 	Method string // GET по-умолчанию в http.NewRequest если передали пустую строку
 	Path   string
 	Query  string
+	JSON   bool // Query is sent as json body
 	Auth   bool
+	Token  string // X-Auth token instead of default one
 	Status int
 	Result interface{}
 }
@@ -250,6 +253,104 @@ func TestMyApi(t *testing.T) {
 	runTests(t, ts, cases)
 }
 
+func TestMyApiRoles(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+
+	const (
+		ApiUserStatus = "/user/status"
+		userToken     = "100200"
+	)
+	cases := []Case{
+		Case{
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=ivanov&status=moderator",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "unauthorized"},
+		},
+		Case{
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=ivanov&status=moderator",
+			Token:  "123",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "unauthorized"},
+		},
+		Case{
+			// user is authenticated, but he isn't admin
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=ivanov&status=admin",
+			Token:  userToken,
+			Status: http.StatusForbidden,
+			Result: CR{"error": "forbidden"},
+		},
+		Case{
+			// method with auth and without roles is available for any user
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "login=created_by_ivanov",
+			Token:  userToken,
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"id": 43}},
+		},
+		Case{
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=ivanov&status=root",
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "status must be one of [user, moderator, admin]"},
+		},
+		Case{
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=nobody&status=admin",
+			Auth:   true,
+			Status: http.StatusNotFound,
+			Result: CR{"error": "user not exist"},
+		},
+		Case{
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=ivanov&status=admin",
+			Auth:   true,
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{
+				"id":        41,
+				"login":     "ivanov",
+				"full_name": "Ivan Ivanov",
+				"status":    statusAdmin,
+			}},
+		},
+		Case{
+			// roles are checked on every request
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&status=user",
+			Token:  userToken,
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{
+				"id":        42,
+				"login":     "rvasily",
+				"full_name": "Vasily Romanov",
+				"status":    statusUser,
+			}},
+		},
+		Case{
+			Path:   ApiUserStatus,
+			Method: http.MethodPost,
+			Query:  "login=ivanov&status=user",
+			Auth:   true,
+			Status: http.StatusForbidden,
+			Result: CR{"error": "forbidden"},
+		},
+	}
+
+	runTests(t, ts, cases)
+}
+
 func TestOtherApi(t *testing.T) {
 	ts := httptest.NewServer(NewOtherApi())
 
@@ -285,6 +386,471 @@ func TestOtherApi(t *testing.T) {
 	runTests(t, ts, cases)
 }
 
+func TestTypedApi(t *testing.T) {
+	ts := httptest.NewServer(NewTypedApi())
+	defer ts.Close()
+
+	typed := func(override CR) CR {
+		result := CR{
+			"id":       7,
+			"active":   true,
+			"rating":   4.5,
+			"birthday": "1990-05-17T10:00:00Z",
+			"tags":     []string{"go", "c"},
+			"scores":   []int{1, 2},
+			"address":  CR{"city": "Moscow", "zip": 12345},
+		}
+		for k, v := range override {
+			result[k] = v
+		}
+		return CR{"error": "", "response": result}
+	}
+	errorResult := func(msg string) CR {
+		return CR{"error": msg}
+	}
+
+	// every pair of inputs must give the same result
+	inputs := []struct {
+		form   string
+		json   string
+		status int
+		result CR
+	}{
+		{
+			"id=7&active=true&rating=4.5&birthday=1990-05-17T10:00:00Z&tags=go&tags=c&scores=1&scores=2&address.city=Moscow&address.zip=12345",
+			`{"id": 7, "active": true, "rating": 4.5, "birthday": "1990-05-17T10:00:00Z", "tags": ["go", "c"], "scores": [1, 2], "address": {"city": "Moscow", "zip": 12345}}`,
+			http.StatusOK,
+			typed(nil),
+		},
+		{
+			// defaults
+			"id=7&address.city=Moscow",
+			`{"id": 7, "address": {"city": "Moscow"}}`,
+			http.StatusOK,
+			CR{"error": "", "response": CR{
+				"id":       7,
+				"active":   false,
+				"rating":   2.5,
+				"birthday": "2000-01-01T00:00:00Z",
+				"address":  CR{"city": "Moscow"},
+			}},
+		},
+		{
+			"address.city=Moscow",
+			`{"address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("id must me not empty"),
+		},
+		{
+			"id=abc&address.city=Moscow",
+			`{"id": "abc", "address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("id must be int"),
+		},
+		{
+			"id=7&active=yes&address.city=Moscow",
+			`{"id": 7, "active": "yes", "address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("active must be bool"),
+		},
+		{
+			"id=7&rating=6&address.city=Moscow",
+			`{"id": 7, "rating": 6, "address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("rating must be <= 5"),
+		},
+		{
+			"id=7&birthday=yesterday&address.city=Moscow",
+			`{"id": 7, "birthday": "yesterday", "address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("birthday must be time in RFC3339 format"),
+		},
+		{
+			"id=7&tags=go&tags=java&address.city=Moscow",
+			`{"id": 7, "tags": ["go", "java"], "address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("tags must be one of [go, rust, c]"),
+		},
+		{
+			"id=7&tags=go&tags=c&tags=go&tags=rust&address.city=Moscow",
+			`{"id": 7, "tags": ["go", "c", "go", "rust"], "address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("tags len must be <= 3"),
+		},
+		{
+			"id=7&scores=1&scores=x&address.city=Moscow",
+			`{"id": 7, "scores": [1, "x"], "address": {"city": "Moscow"}}`,
+			http.StatusBadRequest,
+			errorResult("scores must be list of int"),
+		},
+		{
+			"id=7&address.street=Tverskaya",
+			`{"id": 7, "address": {"street": "Tverskaya"}}`,
+			http.StatusBadRequest,
+			errorResult("address.city must me not empty"),
+		},
+		{
+			"id=7&address.city=Moscow&address.zip=100000",
+			`{"id": 7, "address": {"city": "Moscow", "zip": 100000}}`,
+			http.StatusBadRequest,
+			errorResult("address.zip must be <= 99999"),
+		},
+		{
+			"id=7&address.city=Moscow&address.zip=x",
+			`{"id": 7, "address": {"city": "Moscow", "zip": "x"}}`,
+			http.StatusBadRequest,
+			errorResult("address.zip must be int"),
+		},
+		{
+			"id=13&address.city=Moscow",
+			`{"id": 13, "address": {"city": "Moscow"}}`,
+			http.StatusNotFound,
+			errorResult("object not exist"),
+		},
+	}
+
+	cases := make([]Case, 0, len(inputs)*3+3)
+	for _, in := range inputs {
+		cases = append(cases,
+			Case{Path: "/typed/update", Method: http.MethodPost, Query: in.form, Status: in.status, Result: in.result},
+			Case{Path: "/typed/update", Method: http.MethodPost, Query: in.json, JSON: true, Status: in.status, Result: in.result},
+		)
+	}
+	cases = append(cases,
+		Case{
+			Path:   "/typed/update",
+			Method: http.MethodPost,
+			Query:  `{"id": 7,`,
+			JSON:   true,
+			Status: http.StatusBadRequest,
+			Result: errorResult("bad json body"),
+		},
+		Case{
+			Path:   "/typed/update",
+			Method: http.MethodPost,
+			Query:  `{"id": [7], "address": {"city": "Moscow"}}`,
+			JSON:   true,
+			Status: http.StatusBadRequest,
+			Result: errorResult("id must be int"),
+		},
+		Case{
+			Path:   "/typed/update",
+			Method: http.MethodPost,
+			Query:  `{"id": 7, "address": "Moscow"}`,
+			JSON:   true,
+			Status: http.StatusBadRequest,
+			Result: errorResult("address must be object"),
+		},
+	)
+
+	runTests(t, ts, cases)
+}
+
+func TestValidatorRules(t *testing.T) {
+	ts := httptest.NewServer(NewTypedApi())
+	defer ts.Close()
+
+	const ApiTypedSearch = "/typed/search"
+	valid := "email=vasily@mail.ru&nick=vasily_r"
+	cases := []Case{
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid + "&site=https://mail.ru/&token=123e4567-e89b-12d3-a456-426614174000&labels=go&labels=test&from=2020-01-01T00:00:00Z&to=2020-02-01T00:00:00Z&per_page=50&country=US",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{
+				"email":    "vasily@mail.ru",
+				"site":     "https://mail.ru/",
+				"token":    "123e4567-e89b-12d3-a456-426614174000",
+				"country":  "US",
+				"nick":     "vasily_r",
+				"per_page": 50,
+				"page":     0,
+				"labels":   []string{"go", "test"},
+				"from":     "2020-01-01T00:00:00Z",
+				"to":       "2020-02-01T00:00:00Z",
+			}},
+		},
+		Case{
+			// defaults
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid,
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{
+				"email":    "vasily@mail.ru",
+				"country":  "RU",
+				"nick":     "vasily_r",
+				"per_page": 20,
+				"page":     0,
+				"from":     "0001-01-01T00:00:00Z",
+				"to":       "0001-01-01T00:00:00Z",
+			}},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  "email=vasily.mail.ru&nick=vasily_r",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "email must be valid email"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid + "&site=mail.ru",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "site must be valid url"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid + "&token=123e4567-e89b-12d3-a456",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "token must be valid uuid"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid + "&country=RUS",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "country len must be 2"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  "email=vasily@mail.ru&nick=vasily_romanov_from_mail_ru",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "nick len must be in [3, 16]"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  "email=vasily@mail.ru&nick=1vasily",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "nick must match pattern ^[a-z][a-z0-9_]{2,}$"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid + "&per_page=30",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "perpage must be one of [10, 20, 50]"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid + "&labels=go&labels=Go",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "labels must match pattern ^[a-z]+$"},
+		},
+		Case{
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  valid + "&from=2020-02-01T00:00:00Z&to=2020-01-01T00:00:00Z",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "to must be after from"},
+		},
+		Case{
+			// rules are the same for json body
+			Path:   ApiTypedSearch,
+			Method: http.MethodPost,
+			Query:  `{"email": "vasily@mail.ru", "nick": "vasily_r", "per_page": 30}`,
+			JSON:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "perpage must be one of [10, 20, 50]"},
+		},
+	}
+
+	runTests(t, ts, cases)
+}
+
+// documents are written by generator next to api_handlers.go
+func TestOpenAPI(t *testing.T) {
+	load := func(name string) map[string]interface{} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("cant read OpenAPI document: %v", err)
+		}
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("cant unpack OpenAPI document: %v", err)
+		}
+		return doc
+	}
+	// get returns value by path of keys
+	get := func(v interface{}, keys ...string) interface{} {
+		for _, key := range keys {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = obj[key]
+		}
+		return v
+	}
+
+	doc := load("api_handlers.myapi.openapi.json")
+	cases := []struct {
+		keys     []string
+		expected interface{}
+	}{
+		{[]string{"openapi"}, "3.0.3"},
+		{[]string{"components", "securitySchemes", "apiKey", "name"}, "X-Auth"},
+		{[]string{"components", "securitySchemes", "apiKey", "description"}, "token is checked by MyApi.Authenticate, roles of caller are listed in x-roles of methods"},
+		{[]string{"components", "schemas", "User", "properties", "full_name", "type"}, "string"},
+		{[]string{"components", "schemas", "User", "properties", "id", "format"}, "int64"},
+		{[]string{"paths", "/user/profile", "get", "parameters"}, []interface{}{
+			map[string]interface{}{"name": "login", "in": "query", "required": true, "schema": map[string]interface{}{"type": "string"}},
+		}},
+		{[]string{"paths", "/user/profile", "get", "security"}, nil},
+		{[]string{"paths", "/user/profile", "post", "responses", "200", "content", "application/json", "schema", "properties", "response", "$ref"}, "#/components/schemas/User"},
+		{[]string{"paths", "/user/create", "get"}, nil},
+		{[]string{"paths", "/user/create", "post", "security"}, []interface{}{map[string]interface{}{"apiKey": []interface{}{}}}},
+		{[]string{"paths", "/user/create", "post", "responses", "403", "description"}, "unauthorized"},
+		{[]string{"paths", "/user/create", "post", "x-roles"}, nil},
+		{[]string{"paths", "/user/create", "post", "requestBody", "content", "application/json", "schema", "required"}, []interface{}{"login"}},
+		{[]string{"paths", "/user/create", "post", "requestBody", "content", "application/x-www-form-urlencoded", "schema", "properties", "status"},
+			map[string]interface{}{"type": "string", "enum": []interface{}{"user", "moderator", "admin"}, "default": "user"}},
+		{[]string{"paths", "/user/create", "post", "requestBody", "content", "application/json", "schema", "properties", "age"},
+			map[string]interface{}{"type": "integer", "minimum": 0.0, "maximum": 128.0}},
+		{[]string{"paths", "/user/create", "post", "requestBody", "content", "application/json", "schema", "properties", "login", "minLength"}, 10.0},
+	}
+	for _, item := range cases {
+		if got := get(doc, item.keys...); !reflect.DeepEqual(got, item.expected) {
+			t.Errorf("[%s] got %#v, expected %#v", strings.Join(item.keys, "."), got, item.expected)
+		}
+	}
+
+	// api without Authenticator accepts fixed token
+	doc = load("api_handlers.otherapi.openapi.json")
+	if got := get(doc, "components", "securitySchemes", "apiKey", "description"); got != "fixed token 100500" {
+		t.Errorf("[other security scheme] got %#v", got)
+	}
+
+	doc = load("api_handlers.typedapi.openapi.json")
+	body := get(doc, "paths", "/typed/update", "post", "requestBody", "content", "application/json", "schema", "properties")
+	typedCases := []struct {
+		keys     []string
+		expected interface{}
+	}{
+		{[]string{"birthday"}, map[string]interface{}{"type": "string", "format": "date-time", "default": "2000-01-01T00:00:00Z"}},
+		{[]string{"rating", "default"}, 2.5},
+		{[]string{"tags"}, map[string]interface{}{
+			"type":     "array",
+			"items":    map[string]interface{}{"type": "string", "enum": []interface{}{"go", "rust", "c"}},
+			"maxItems": 3.0,
+		}},
+		{[]string{"address", "required"}, []interface{}{"city"}},
+		{[]string{"address", "properties", "zip", "maximum"}, 99999.0},
+	}
+	for _, item := range typedCases {
+		if got := get(body, item.keys...); !reflect.DeepEqual(got, item.expected) {
+			t.Errorf("[typed %s] got %#v, expected %#v", strings.Join(item.keys, "."), got, item.expected)
+		}
+	}
+
+	search := get(doc, "paths", "/typed/search", "post", "requestBody", "content", "application/json", "schema", "properties")
+	searchCases := []struct {
+		keys     []string
+		expected interface{}
+	}{
+		{[]string{"email", "format"}, "email"},
+		{[]string{"site", "format"}, "uri"},
+		{[]string{"token", "format"}, "uuid"},
+		{[]string{"country"}, map[string]interface{}{"type": "string", "default": "RU", "minLength": 2.0, "maxLength": 2.0}},
+		{[]string{"nick", "pattern"}, "^[a-z][a-z0-9_]{2,}$"},
+		{[]string{"per_page", "enum"}, []interface{}{10.0, 20.0, 50.0}},
+		{[]string{"page", "default"}, 0.0},
+		{[]string{"labels", "items", "pattern"}, "^[a-z]+$"},
+	}
+	for _, item := range searchCases {
+		if got := get(search, item.keys...); !reflect.DeepEqual(got, item.expected) {
+			t.Errorf("[search %s] got %#v, expected %#v", strings.Join(item.keys, "."), got, item.expected)
+		}
+	}
+}
+
+// clients are written by generator with -client flag to api_client.go
+func TestApiClient(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+
+	// checkErr checks that err is ApiError with status and message
+	checkErr := func(name string, err error, status int, msg string) {
+		ae, ok := err.(ApiError)
+		if !ok {
+			t.Errorf("[%s] expected ApiError, got %#v", name, err)
+			return
+		}
+		if ae.HTTPStatus != status || ae.Error() != msg {
+			t.Errorf("[%s] expected %d %q, got %d %q", name, status, msg, ae.HTTPStatus, ae.Error())
+		}
+	}
+
+	ctx := context.Background()
+	cli := NewMyApiClient(ts.URL, "")
+
+	user, err := cli.Profile(ctx, ProfileParams{Login: "rvasily"})
+	expected := &User{ID: 42, Login: "rvasily", FullName: "Vasily Romanov", Status: statusAdmin}
+	if err != nil || !reflect.DeepEqual(user, expected) {
+		t.Errorf("[profile] got %#v, %v, expected %#v", user, err, expected)
+	}
+	_, err = cli.Profile(ctx, ProfileParams{Login: "nobody"})
+	checkErr("profile not exist", err, http.StatusNotFound, "user not exist")
+	_, err = cli.Profile(ctx, ProfileParams{})
+	checkErr("profile empty", err, http.StatusBadRequest, "login must me not empty")
+	_, err = cli.Profile(ctx, ProfileParams{Login: "bad_user"})
+	checkErr("profile bad", err, http.StatusInternalServerError, "bad user")
+
+	params := CreateParams{Login: "mr.moderator", Name: "Ivan Ivanov", Status: "moderator", Age: 32}
+	_, err = cli.Create(ctx, params)
+	checkErr("create without auth", err, http.StatusForbidden, "unauthorized")
+
+	cli.AuthToken = "100500"
+	created, err := cli.Create(ctx, params)
+	if err != nil || created.ID == 0 {
+		t.Errorf("[create] got %#v, %v", created, err)
+	}
+	user, err = cli.Profile(ctx, ProfileParams{Login: "mr.moderator"})
+	expected = &User{ID: created.ID, Login: "mr.moderator", FullName: "Ivan Ivanov", Status: statusModerator}
+	if err != nil || !reflect.DeepEqual(user, expected) {
+		t.Errorf("[created profile] got %#v, %v, expected %#v", user, err, expected)
+	}
+	_, err = cli.Create(ctx, params)
+	checkErr("create twice", err, http.StatusConflict, "user mr.moderator exist")
+	_, err = cli.Create(ctx, CreateParams{Login: "short"})
+	checkErr("create short", err, http.StatusBadRequest, "login len must be >= 10")
+
+	otherTS := httptest.NewServer(NewOtherApi())
+	defer otherTS.Close()
+	other, err := NewOtherApiClient(otherTS.URL, "100500").Create(ctx, OtherCreateParams{Username: "I3apBap", Name: "Vasily", Level: 1})
+	expectedOther := &OtherUser{ID: 12, Login: "I3apBap", FullName: "Vasily", Level: 1}
+	if err != nil || !reflect.DeepEqual(other, expectedOther) {
+		t.Errorf("[other create] got %#v, %v, expected %#v", other, err, expectedOther)
+	}
+
+	typedTS := httptest.NewServer(NewTypedApi())
+	defer typedTS.Close()
+	typedCli := NewTypedApiClient(typedTS.URL, "")
+	in := &TypedParams{
+		ID:       7,
+		Active:   true,
+		Rating:   4.25,
+		Birthday: time.Date(1990, 5, 17, 10, 0, 0, 0, time.UTC),
+		Tags:     []string{"go", "c"},
+		Scores:   []int{1, 2},
+		Address:  Address{City: "Moscow", Street: "Tverskaya", Zip: 12345},
+	}
+	typed, err := typedCli.Update(ctx, in)
+	if err != nil || !reflect.DeepEqual(typed, in) {
+		t.Errorf("[typed update] got %#v, %v, expected %#v", typed, err, in)
+	}
+	_, err = typedCli.Update(ctx, &TypedParams{ID: 7})
+	checkErr("typed nested", err, http.StatusBadRequest, "address.city must me not empty")
+	_, err = typedCli.Update(ctx, nil)
+	checkErr("typed nil", err, http.StatusBadRequest, "id must me not empty")
+}
+
 func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 	for idx, item := range cases {
 		var (
@@ -296,7 +862,11 @@ func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 
 		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
 
-		if item.Method == http.MethodPost {
+		if item.JSON {
+			reqBody := strings.NewReader(item.Query)
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
+			req.Header.Add("Content-Type", "application/json")
+		} else if item.Method == http.MethodPost {
 			reqBody := strings.NewReader(item.Query)
 			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
@@ -304,7 +874,9 @@ func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
 		}
 
-		if item.Auth {
+		if item.Token != "" {
+			req.Header.Add("X-Auth", item.Token)
+		} else if item.Auth {
 			req.Header.Add("X-Auth", "100500")
 		}
 